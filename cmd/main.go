@@ -2,16 +2,42 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"mica-shim/core"
 	defs "mica-shim/definitions"
+	"mica-shim/io"
 	log "mica-shim/logger"
+	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/containerd/containerd/namespaces"
 	"github.com/containerd/containerd/runtime/v2/shim"
 )
 
 func main() {
+	// containerd always passes flags first, so a leading word is one of the
+	// shim's own debug commands
+	if len(os.Args) > 1 {
+		var cmd func([]string) error
+		switch os.Args[1] {
+		case "console-history":
+			cmd = consoleHistory
+		case "gc":
+			cmd = gc
+		}
+		if cmd != nil {
+			if err := cmd(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	log.CleanDebugFile()
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
@@ -23,3 +49,109 @@ func main() {
 	// 1.7.1-0.20230727135123-81895d22c9ee and later, the shim.Run parameters are changed
 	shim.Run(ctx, core.NewManager(defs.ShimName))
 }
+
+// consoleHistory dumps the console history a running shim keeps for a task.
+func consoleHistory(args []string) error {
+	fs := flag.NewFlagSet("console-history", flag.ContinueOnError)
+	ns := fs.String("namespace", namespaces.Default, "namespace of the task")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s console-history [-namespace ns] <id> [stdout|stderr]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		return fmt.Errorf("expected a task id and an optional stream")
+	}
+
+	stream := "stdout"
+	if fs.NArg() == 2 {
+		stream = fs.Arg(1)
+	}
+	return io.DumpHistory(os.Stdout, core.ConsoleHistorySocket(*ns, fs.Arg(0)), stream)
+}
+
+// gc removes the micad clients the shim created for tasks that are gone and
+// releases their firmware, once or every interval.
+func gc(args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ContinueOnError)
+	root := fs.String("root", core.DefaultBundleRoot, "directory of the task bundles by namespace")
+	dryRun := fs.Bool("dry-run", false, "print what would be removed")
+	interval := fs.Duration("interval", 0, "collect every interval until interrupted")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s gc [-root dir] [-dry-run] [-interval d]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected arguments")
+	}
+
+	if *interval <= 0 {
+		return collect(*root, *dryRun)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		if err := collect(*root, *dryRun); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// collect removes the orphaned micad clients and releases the firmware of
+// the tasks that are gone, or only prints them.
+func collect(root string, dryRun bool) error {
+	orphans, err := core.OrphanClients(root)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, client := range orphans {
+		if dryRun {
+			fmt.Printf("would remove mica client %s\n", client)
+			continue
+		}
+		if err := core.RemoveClient(client); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fmt.Printf("removed mica client %s\n", client)
+	}
+
+	// the firmware references tell which clients the shim created, so they
+	// are kept until the clients are gone
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	owners, err := core.OrphanOwners(root)
+	if err != nil {
+		return err
+	}
+	for _, owner := range owners {
+		if dryRun {
+			fmt.Printf("would release firmware of task %s\n", owner)
+			continue
+		}
+		if err := core.ReleaseOwner(owner); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fmt.Printf("released firmware of task %s\n", owner)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,235 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	goio "io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	defs "mica-shim/definitions"
+	"mica-shim/firmware"
+	log "mica-shim/logger"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Files of a checkpoint directory.
+const (
+	checkpointConfigFile = "config.json"
+	checkpointFirmware   = "firmware.elf"
+	checkpointMemoryDir  = "memory"
+)
+
+// checkpointVersion is the version of the checkpoint format.
+const checkpointVersion = 1
+
+// devMem is the physical memory the RTOS memory is dumped from.
+var devMem = "/dev/mem"
+
+// checkpoint describes a task as written by Checkpoint: what it takes to
+// create an identical mica client, plus a dump of the RTOS memory when the
+// host lets the shim read it. The dump is for inspection only, a restored
+// client boots its firmware from scratch.
+type checkpoint struct {
+	Version  int           `json:"version"`
+	Firmware digest.Digest `json:"firmware"`
+	// Create holds the create message sent to micad.
+	Create checkpointCreate `json:"create"`
+	// Annotations are the annotations of the task's spec.
+	Annotations map[string]string  `json:"annotations,omitempty"`
+	Memory      []checkpointMemory `json:"memory,omitempty"`
+}
+
+// checkpointCreate is the create message of a checkpointed client.
+type checkpointCreate struct {
+	CPU  uint32 `json:"cpu"`
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// checkpointMemory is a dumped range of the RTOS memory, one per loadable
+// segment of the firmware.
+type checkpointMemory struct {
+	Paddr uint64 `json:"paddr"`
+	Size  uint64 `json:"size"`
+	File  string `json:"file"`
+}
+
+// checkpointSource is the task a checkpoint is written of. It is taken from
+// the task under s.m, so that writing can go without it.
+type checkpointSource struct {
+	bundle    string
+	client    string
+	cpu       uint32
+	image     firmware.Image
+	signature string
+	segments  []firmware.Segment
+}
+
+// checkpointSource returns the task run by proc a checkpoint is written of.
+// s.m must be held.
+func (proc *initProcess) checkpointSource() checkpointSource {
+	return checkpointSource{
+		bundle:    proc.bundle,
+		client:    proc.client,
+		cpu:       proc.cpu,
+		image:     proc.image,
+		signature: proc.signature,
+		segments:  proc.meta.Segments,
+	}
+}
+
+// writeCheckpoint writes a checkpoint of the task src to dir.
+func writeCheckpoint(dir string, src checkpointSource, spec *specs.Spec) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("creating checkpoint directory: %w", err)
+	}
+
+	fwPath := filepath.Join(dir, checkpointFirmware)
+	if err := copyFile(fwPath, src.image.Path); err != nil {
+		return fmt.Errorf("copying firmware to checkpoint: %w", err)
+	}
+	// keep the signature, so that the restored firmware is admitted alike
+	if src.signature != "" {
+		err := copyFile(fwPath+firmware.SignatureExt, src.signature)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("copying firmware signature to checkpoint: %w", err)
+		}
+	}
+
+	cp := checkpoint{
+		Version:  checkpointVersion,
+		Firmware: src.image.Digest,
+		Create: checkpointCreate{
+			CPU:  src.cpu,
+			Name: src.client,
+			Path: src.image.Path,
+		},
+		Annotations: spec.Annotations,
+	}
+
+	mem, err := dumpMemory(filepath.Join(dir, checkpointMemoryDir), src.segments)
+	if err != nil {
+		log.WithError(err).Warnf("checkpoint of mica client %s has no memory dump", src.client)
+	}
+	cp.Memory = mem
+
+	data, err := json.MarshalIndent(&cp, "", "\t")
+	if err != nil {
+		return fmt.Errorf("encoding checkpoint: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, checkpointConfigFile), data, 0o644); err != nil {
+		return fmt.Errorf("writing checkpoint: %w", err)
+	}
+	return nil
+}
+
+// readCheckpoint reads the checkpoint in dir.
+func readCheckpoint(dir string) (*checkpoint, error) {
+	data, err := os.ReadFile(filepath.Join(dir, checkpointConfigFile))
+	if err != nil {
+		return nil, fmt.Errorf("reading checkpoint: %w", err)
+	}
+
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("decoding checkpoint %s: %v: %w", dir, err, errdefs.ErrInvalidArgument)
+	}
+	if cp.Version != checkpointVersion {
+		return nil, fmt.Errorf("checkpoint %s has version %d, expected %d: %w",
+			dir, cp.Version, checkpointVersion, errdefs.ErrInvalidArgument)
+	}
+	if err := cp.Firmware.Validate(); err != nil {
+		return nil, fmt.Errorf("checkpoint %s: firmware digest %q: %v: %w",
+			dir, cp.Firmware, err, errdefs.ErrInvalidArgument)
+	}
+	return &cp, nil
+}
+
+// apply sets the mica annotations of the checkpointed task on spec, which
+// configure the console and the shell of the restored task alike.
+func (cp *checkpoint) apply(spec *specs.Spec) {
+	for k, v := range cp.Annotations {
+		if !strings.HasPrefix(k, defs.MicaAnnotationPrefix) {
+			continue
+		}
+		if spec.Annotations == nil {
+			spec.Annotations = make(map[string]string)
+		}
+		spec.Annotations[k] = v
+	}
+}
+
+// dumpMemory dumps the physical memory the firmware segments are loaded to
+// into dir. It fails if the host does not give access to that memory, e.g.
+// with CONFIG_STRICT_DEVMEM.
+func dumpMemory(dir string, segs []firmware.Segment) ([]checkpointMemory, error) {
+	mem, err := os.Open(devMem)
+	if err != nil {
+		return nil, fmt.Errorf("opening physical memory: %w", err)
+	}
+	defer mem.Close()
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating memory dump directory: %w", err)
+	}
+
+	var dumps []checkpointMemory
+	for _, seg := range segs {
+		if seg.Memsz == 0 {
+			continue
+		}
+		name := fmt.Sprintf("%#x.bin", seg.Paddr)
+		if err := dumpRange(filepath.Join(dir, name), mem, seg.Paddr, seg.Memsz); err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		dumps = append(dumps, checkpointMemory{
+			Paddr: seg.Paddr,
+			Size:  seg.Memsz,
+			File:  filepath.Join(checkpointMemoryDir, name),
+		})
+	}
+	return dumps, nil
+}
+
+// dumpRange writes size bytes of mem at paddr to the file at path.
+func dumpRange(path string, mem *os.File, paddr, size uint64) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating memory dump: %w", err)
+	}
+	defer f.Close()
+
+	r := goio.NewSectionReader(mem, int64(paddr), int64(size))
+	if n, err := goio.Copy(f, r); err != nil {
+		return fmt.Errorf("dumping memory at %#x: %w", paddr, err)
+	} else if uint64(n) != size {
+		return fmt.Errorf("dumping memory at %#x: read %d of %d bytes", paddr, n, size)
+	}
+	return nil
+}
+
+// copyFile copies the regular file at src to dst.
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := goio.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
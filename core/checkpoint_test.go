@@ -0,0 +1,101 @@
+package core
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	defs "mica-shim/definitions"
+	"mica-shim/firmware"
+
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestCheckpoint(t *testing.T) {
+	tmp := t.TempDir()
+	rootfs := filepath.Join(tmp, "rootfs")
+	if err := os.Mkdir(rootfs, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	elf := []byte("\x7fELF zephyr")
+	for name, data := range map[string][]byte{
+		"zephyr.elf":     elf,
+		"zephyr.elf.sig": []byte("signature"),
+	} {
+		if err := os.WriteFile(filepath.Join(rootfs, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mem := bytes.Repeat([]byte{0xaa}, 64)
+	copy(mem[16:], "rtos memory")
+	defer func(path string) { devMem = path }(devMem)
+	devMem = filepath.Join(tmp, "mem")
+	if err := os.WriteFile(devMem, mem, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	proc := &initProcess{
+		rootfs: rootfs,
+		client: "zephyr",
+		cpu:    3,
+		image: firmware.Image{
+			Digest: digest.FromBytes(elf),
+			Path:   filepath.Join(rootfs, "zephyr.elf"),
+		},
+		signature: filepath.Join(rootfs, "zephyr.elf.sig"),
+		meta:      &firmware.Metadata{Segments: []firmware.Segment{{Paddr: 16, Memsz: 11}}},
+	}
+	spec := &specs.Spec{
+		Process: &specs.Process{Args: []string{"/zephyr.elf"}},
+		Annotations: map[string]string{
+			defs.AnnotationCPU:             "3",
+			defs.AnnotationShellPrompt:     "zephyr:~# ",
+			"io.kubernetes.cri.sandbox-id": "sandbox",
+		},
+	}
+
+	dir := filepath.Join(tmp, "checkpoint")
+	if err := writeCheckpoint(dir, proc.checkpointSource(), spec); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		checkpointFirmware:                  string(elf),
+		checkpointFirmware + ".sig":         "signature",
+		filepath.Join("memory", "0x10.bin"): "rtos memory",
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(data) != want {
+			t.Errorf("unexpected %s %q: %v", name, data, err)
+		}
+	}
+
+	cp, err := readCheckpoint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Firmware != proc.image.Digest || cp.Create.CPU != 3 || cp.Create.Name != "zephyr" {
+		t.Errorf("unexpected checkpoint %+v", cp)
+	}
+	if len(cp.Memory) != 1 || cp.Memory[0].Paddr != 16 || cp.Memory[0].Size != 11 {
+		t.Errorf("unexpected memory dumps %+v", cp.Memory)
+	}
+
+	restored := &specs.Spec{Annotations: map[string]string{defs.AnnotationShellPrompt: "uart:~$ "}}
+	cp.apply(restored)
+	if len(restored.Annotations) != 2 || restored.Annotations[defs.AnnotationShellPrompt] != "zephyr:~# " {
+		t.Errorf("unexpected restored annotations %v", restored.Annotations)
+	}
+
+	// without access to the physical memory, the checkpoint has no dump
+	devMem = filepath.Join(tmp, "nomem")
+	dir = filepath.Join(tmp, "nodump")
+	if err := writeCheckpoint(dir, proc.checkpointSource(), spec); err != nil {
+		t.Fatal(err)
+	}
+	if cp, err := readCheckpoint(dir); err != nil || len(cp.Memory) != 0 {
+		t.Errorf("expected a checkpoint without memory dump, got %+v: %v", cp, err)
+	}
+}
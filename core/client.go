@@ -0,0 +1,110 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	defs "mica-shim/definitions"
+	"mica-shim/libmica"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// clientDigestLen is the number of hex digits of the digest in client names.
+const clientDigestLen = 12
+
+// clientName returns the name of the mica client backing task id in namespace
+// ns. micad stores names as NUL-terminated strings too short for container
+// IDs, so the name is a prefix of the ID, for the logs, followed by a digest
+// of ns/id, which tells apart the tasks of all namespaces.
+func clientName(ns, id string) string {
+	d := digest.FromString(ns + "/" + id).Encoded()[:clientDigestLen]
+	if max := libmica.MaxNameLen - 2 - clientDigestLen; len(id) > max {
+		id = id[:max]
+	}
+	return id + "-" + d
+}
+
+// clientCPU returns the CPU the RTOS client of a task should run on, taken
+// from the AnnotationCPU annotation or the first CPU of the task's cpuset.
+func clientCPU(spec *specs.Spec) (uint32, error) {
+	if v, ok := spec.Annotations[defs.AnnotationCPU]; ok {
+		cpu, err := strconv.ParseUint(strings.TrimSpace(v), 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("parsing %s annotation %q: %w", defs.AnnotationCPU, v, errdefs.ErrInvalidArgument)
+		}
+		return uint32(cpu), nil
+	}
+
+	if spec.Linux != nil && spec.Linux.Resources != nil && spec.Linux.Resources.CPU != nil &&
+		spec.Linux.Resources.CPU.Cpus != "" {
+		return firstCPU(spec.Linux.Resources.CPU.Cpus)
+	}
+
+	return 0, fmt.Errorf("no cpu assigned, set linux.resources.cpu.cpus or the %s annotation: %w",
+		defs.AnnotationCPU, errdefs.ErrInvalidArgument)
+}
+
+// firstCPU returns the first CPU of a cpuset list such as "3" or "2-3,6".
+func firstCPU(cpuset string) (uint32, error) {
+	first := strings.TrimSpace(strings.SplitN(cpuset, ",", 2)[0])
+	first = strings.SplitN(first, "-", 2)[0]
+	cpu, err := strconv.ParseUint(first, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("parsing cpuset %q: %w", cpuset, errdefs.ErrInvalidArgument)
+	}
+	return uint32(cpu), nil
+}
+
+// cpusetContains tells whether the cpuset list cpuset has cpu.
+func cpusetContains(cpuset string, cpu uint32) (bool, error) {
+	for _, r := range strings.Split(cpuset, ",") {
+		lo, hi, isRange := strings.Cut(strings.TrimSpace(r), "-")
+		first, err := strconv.ParseUint(lo, 10, 32)
+		if err != nil {
+			return false, fmt.Errorf("parsing cpuset %q: %w", cpuset, errdefs.ErrInvalidArgument)
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.ParseUint(hi, 10, 32); err != nil {
+				return false, fmt.Errorf("parsing cpuset %q: %w", cpuset, errdefs.ErrInvalidArgument)
+			}
+		}
+		if uint64(cpu) >= first && uint64(cpu) <= last {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// micaCtl sends cmd to the mica client of proc, counting the failures.
+func (proc *initProcess) micaCtl(cmd libmica.MicaCommand) error {
+	if _, err := libmica.MicaCtl(cmd, proc.client); err != nil {
+		proc.micadErrors.Add(1)
+		return err
+	}
+	return nil
+}
+
+// micaStatus queries micad for the status of the mica client of proc,
+// counting the failures.
+func (proc *initProcess) micaStatus() (*libmica.ClientStatus, error) {
+	st, err := libmica.MicaStatus(proc.client)
+	if err != nil {
+		proc.micadErrors.Add(1)
+	}
+	return st, err
+}
+
+// micaCreate creates the mica client of proc on cpu, counting the failures.
+func (proc *initProcess) micaCreate(cpu uint32) error {
+	msg := libmica.NewMicaCreateMsg(cpu, proc.client, proc.image.Path, "", "", false)
+	if _, err := libmica.MicaCreate(msg); err != nil {
+		proc.micadErrors.Add(1)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"mica-shim/libmica"
+)
+
+func TestClientName(t *testing.T) {
+	long := strings.Repeat("0123456789abcdef", 4)
+	names := make(map[string]bool)
+	for _, task := range [][2]string{
+		{"default", "zephyr"},
+		{"k8s.io", "zephyr"},
+		{"default", long},
+		{"default", long[:40]},
+	} {
+		name := clientName(task[0], task[1])
+		if len(name) >= libmica.MaxNameLen {
+			t.Errorf("client name %q of %s/%s is too long", name, task[0], task[1])
+		}
+		if names[name] {
+			t.Errorf("client name %q of %s/%s is taken", name, task[0], task[1])
+		}
+		names[name] = true
+	}
+
+	if name := clientName("default", "zephyr"); !strings.HasPrefix(name, "zephyr-") || name != clientName("default", "zephyr") {
+		t.Errorf("unexpected client name %q", name)
+	}
+}
+
+func TestCPUSetContains(t *testing.T) {
+	for _, tc := range []struct {
+		cpuset string
+		cpu    uint32
+		want   bool
+	}{
+		{"3", 3, true},
+		{"2-3", 3, true},
+		{"2-3", 2, true},
+		{"2-3,6", 6, true},
+		{"0, 2-3", 1, false},
+		{"2-3,6", 4, false},
+	} {
+		got, err := cpusetContains(tc.cpuset, tc.cpu)
+		if err != nil || got != tc.want {
+			t.Errorf("cpusetContains(%q, %d) = %v, %v, expected %v", tc.cpuset, tc.cpu, got, err, tc.want)
+		}
+	}
+
+	for _, cpuset := range []string{"", "a", "1-b"} {
+		if _, err := cpusetContains(cpuset, 1); err == nil {
+			t.Errorf("expected an error for cpuset %q", cpuset)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	defs "mica-shim/definitions"
+	log "mica-shim/logger"
+
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// consoleAttachTimeout bounds how long a started client may take to
+	// bring up its console device.
+	consoleAttachTimeout = 30 * time.Second
+	consolePollInterval  = 500 * time.Millisecond
+)
+
+// attachConsole waits for the console device of a started task to show up
+// and bridges it to the task's stdio, for as long as the init process runs.
+func (s *micaTaskService) attachConsole(id string, proc *initProcess) {
+	ctx, cancel := context.WithTimeout(proc.doneCtx, consoleAttachTimeout)
+	defer cancel()
+
+	dev, err := locateConsole(ctx, proc)
+	if err != nil {
+		log.WithError(err).Warnf("no console for task %s", id)
+		return
+	}
+
+	log.Infof("attaching console %s to task %s", dev, id)
+	if err := proc.console.Attach(proc.doneCtx, dev, proc.logDevice); err != nil {
+		log.WithError(err).Warnf("failed to attach console %s to task %s", dev, id)
+	}
+}
+
+// reattachConsole attaches the console of a restarted task again, once the
+// console device of its previous run hung up.
+func (s *micaTaskService) reattachConsole(id string, proc *initProcess) {
+	if done := proc.console.Done(); done != nil {
+		select {
+		case <-done:
+		case <-proc.doneCtx.Done():
+			return
+		case <-time.After(consoleAttachTimeout):
+			// the device did not go away, the console is still attached
+			return
+		}
+	}
+	s.attachConsole(id, proc)
+}
+
+// locateConsole returns the console device of a task, polling micad for the
+// RPMsg TTY of its client unless the device was set by annotation.
+func locateConsole(ctx context.Context, proc *initProcess) (string, error) {
+	ticker := time.NewTicker(consolePollInterval)
+	defer ticker.Stop()
+
+	for {
+		dev := proc.consoleDevice
+		if dev == "" {
+			if st, err := proc.micaStatus(); err == nil {
+				dev = st.TTY()
+			}
+		}
+		if dev != "" {
+			if _, err := os.Stat(dev); err == nil {
+				return dev, nil
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return "", fmt.Errorf("checking console device %s: %w", dev, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("waiting for console device of client %s: %w", proc.client, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// ConsoleHistorySocket returns the path of the socket serving the console
+// history of task id in namespace ns. Task ids can be too long for a socket
+// path, so it is named after their digest.
+func ConsoleHistorySocket(ns, id string) string {
+	d := digest.FromString(ns + "/" + id)
+	return filepath.Join(defs.ShimStateDir, "console", d.Encoded()[:16]+".sock")
+}
@@ -0,0 +1,177 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mica-shim/firmware"
+	log "mica-shim/logger"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// Roots of the kernel file systems crash data is read from.
+var (
+	sysfsRoot   = "/sys"
+	debugfsRoot = "/sys/kernel/debug"
+)
+
+const (
+	// crashDir is the directory of the bundle crash data is collected in,
+	// one directory per crash.
+	crashDir = "crash"
+	// crashMetadataFile describes a crash in its directory.
+	crashMetadataFile = "metadata.json"
+)
+
+// crashMetadata describes a crash of the RTOS of a task.
+type crashMetadata struct {
+	Time       time.Time     `json:"time"`
+	Client     string        `json:"client"`
+	Firmware   digest.Digest `json:"firmware"`
+	CPU        uint32        `json:"cpu"`
+	Reason     string        `json:"reason"`
+	ExitStatus int           `json:"exit_status"`
+	// Remoteproc is the remote processor the client ran on, empty if it
+	// could not be told, and Files the crash data collected from it.
+	Remoteproc string   `json:"remoteproc,omitempty"`
+	Files      []string `json:"files,omitempty"`
+}
+
+// crashSource is the client crash data is collected about, as it ran. It is
+// taken from the task under s.m, so that collecting can go without it.
+type crashSource struct {
+	bundle string
+	client string
+	image  firmware.Image
+	cpu    uint32
+}
+
+// crashSource returns the client of proc crash data is collected about. s.m
+// must be held.
+func (proc *initProcess) crashSource() crashSource {
+	return crashSource{bundle: proc.bundle, client: proc.client, image: proc.image, cpu: proc.cpu}
+}
+
+// collectCrash copies the crash data the kernel exposes for the remote
+// processor of src, its devcoredumps and trace buffers, into a new directory
+// of <bundle>/crash, along with a description of the crash. It returns that
+// directory.
+func collectCrash(src crashSource, reason string, status int) (string, error) {
+	now := time.Now().UTC()
+	dir := filepath.Join(src.bundle, crashDir, now.Format("20060102T150405.000000000Z"))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("creating crash directory: %w", err)
+	}
+
+	meta := crashMetadata{
+		Time:       now,
+		Client:     src.client,
+		Firmware:   src.image.Digest,
+		CPU:        src.cpu,
+		Reason:     reason,
+		ExitStatus: status,
+	}
+
+	rproc, err := findRemoteproc(filepath.Base(src.image.Path))
+	if err != nil {
+		log.WithError(err).Warnf("failed to find the remote processor of mica client %s", src.client)
+	}
+	if rproc != "" {
+		meta.Remoteproc = filepath.Base(rproc)
+		meta.Files = append(meta.Files, copyTraces(dir, meta.Remoteproc)...)
+		meta.Files = append(meta.Files, copyCoredumps(dir, rproc)...)
+	}
+
+	data, err := json.MarshalIndent(&meta, "", "\t")
+	if err != nil {
+		return "", fmt.Errorf("encoding crash metadata: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, crashMetadataFile), data, 0o644); err != nil {
+		return "", fmt.Errorf("writing crash metadata: %w", err)
+	}
+	return dir, nil
+}
+
+// recordCrash collects the crash data of the client src, logging failures.
+// s.m must not be held, copying the data takes its time.
+func recordCrash(src crashSource, reason string, status int) {
+	dir, err := collectCrash(src, reason, status)
+	if err != nil {
+		log.WithError(err).Warnf("failed to collect crash data of mica client %s", src.client)
+		return
+	}
+	log.Infof("collected crash data of mica client %s into %s", src.client, dir)
+}
+
+// findRemoteproc returns the sysfs directory of the remote processor running
+// the firmware named fw, or the only remote processor of the host. It
+// returns an empty path if there is none.
+func findRemoteproc(fw string) (string, error) {
+	rprocs, err := filepath.Glob(filepath.Join(sysfsRoot, "class", "remoteproc", "remoteproc*"))
+	if err != nil {
+		return "", err
+	}
+	for _, rproc := range rprocs {
+		data, err := os.ReadFile(filepath.Join(rproc, "firmware"))
+		if err != nil {
+			continue
+		}
+		if filepath.Base(strings.TrimSpace(string(data))) == fw {
+			return rproc, nil
+		}
+	}
+	if len(rprocs) == 1 {
+		return rprocs[0], nil
+	}
+	return "", nil
+}
+
+// copyTraces copies the trace buffers of the remote processor named rproc
+// in debugfs into dir, and returns the names of the copies.
+func copyTraces(dir, rproc string) []string {
+	traces, _ := filepath.Glob(filepath.Join(debugfsRoot, "remoteproc", rproc, "trace*"))
+	var files []string
+	for _, trace := range traces {
+		name := filepath.Base(trace)
+		if err := copyFile(filepath.Join(dir, name), trace); err != nil {
+			log.WithError(err).Warnf("failed to copy %s trace buffer %s", rproc, name)
+			continue
+		}
+		files = append(files, name)
+	}
+	return files
+}
+
+// copyCoredumps copies the devcoredumps of the remote processor whose sysfs
+// directory is rproc into dir, and returns the names of the copies.
+func copyCoredumps(dir, rproc string) []string {
+	dev, err := filepath.EvalSymlinks(rproc)
+	if err != nil {
+		return nil
+	}
+
+	dumps, _ := filepath.Glob(filepath.Join(sysfsRoot, "class", "devcoredump", "devcd*"))
+	var files []string
+	for _, dump := range dumps {
+		failing, err := filepath.EvalSymlinks(filepath.Join(dump, "failing_device"))
+		if err != nil || failing != dev {
+			continue
+		}
+		name := "devcoredump-" + filepath.Base(dump)
+		if err := copyFile(filepath.Join(dir, name), filepath.Join(dump, "data")); err != nil {
+			// the dump goes away once dismissed or expired
+			if !errors.Is(err, os.ErrNotExist) {
+				log.WithError(err).Warnf("failed to copy devcoredump %s", filepath.Base(dump))
+			}
+			continue
+		}
+		files = append(files, name)
+	}
+	return files
+}
@@ -0,0 +1,90 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"mica-shim/firmware"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestCollectCrash(t *testing.T) {
+	tmp := t.TempDir()
+	defer func(sys, debug string) { sysfsRoot, debugfsRoot = sys, debug }(sysfsRoot, debugfsRoot)
+	sysfsRoot, debugfsRoot = filepath.Join(tmp, "sys"), filepath.Join(tmp, "debug")
+
+	// two remote processors, remoteproc1 running the task's firmware and
+	// having crashed, remoteproc0 having crashed too
+	class := filepath.Join(sysfsRoot, "class")
+	for i, fw := range []string{"other.elf", "0123.elf"} {
+		dev := filepath.Join(sysfsRoot, "devices", "rproc", fmt.Sprintf("remoteproc%d", i))
+		dump := filepath.Join(sysfsRoot, "devices", "devcd", fmt.Sprintf("devcd%d", i+1))
+		files := map[string]string{
+			filepath.Join(dev, "firmware"):                                         fw + "\n",
+			filepath.Join(debugfsRoot, "remoteproc", filepath.Base(dev), "trace0"): "trace of " + fw,
+			filepath.Join(dump, "data"):                                            "core of " + fw,
+		}
+		for path, data := range files {
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		links := map[string]string{
+			filepath.Join(class, "remoteproc", filepath.Base(dev)):   dev,
+			filepath.Join(class, "devcoredump", filepath.Base(dump)): dump,
+			filepath.Join(dump, "failing_device"):                    dev,
+		}
+		for link, target := range links {
+			if err := os.MkdirAll(filepath.Dir(link), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink(target, link); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	proc := &initProcess{
+		bundle: filepath.Join(tmp, "bundle"),
+		client: "zephyr",
+		cpu:    3,
+		image:  firmware.Image{Digest: digest.FromString("zephyr"), Path: "/var/lib/mica/firmware/0123.elf"},
+	}
+	dir, err := collectCrash(proc.crashSource(), "mica client zephyr is Crashed", exitStatusStopped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(dir) != filepath.Join(proc.bundle, crashDir) {
+		t.Errorf("unexpected crash directory %s", dir)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, crashMetadataFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta crashMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.Remoteproc != "remoteproc1" || meta.Firmware != proc.image.Digest || meta.CPU != 3 ||
+		meta.ExitStatus != exitStatusStopped || len(meta.Files) != 2 {
+		t.Errorf("unexpected crash metadata %+v", meta)
+	}
+
+	for name, want := range map[string]string{
+		"trace0":             "trace of 0123.elf",
+		"devcoredump-devcd2": "core of 0123.elf",
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(data) != want {
+			t.Errorf("unexpected %s %q: %v", name, data, err)
+		}
+	}
+}
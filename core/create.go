@@ -1 +1,122 @@
 package core
+
+import (
+	"context"
+	"fmt"
+	goio "io"
+	"net"
+	"os/exec"
+	"syscall"
+
+	"mica-shim/io"
+	log "mica-shim/logger"
+
+	eventstypes "github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/protobuf"
+	"github.com/containerd/containerd/runtime"
+	"github.com/containerd/containerd/sys/reaper"
+)
+
+// initArgs is the command line of the init process of a task.
+var initArgs = []string{"sh", "-c", "while sleep 5; do :; done"}
+
+// openConsole sets up the console of task id in namespace ns as configured by
+// cfg, along with the socket serving its history and its log at logPath. The
+// console is attached once the client is started, as its device only shows
+// up when the RTOS boots.
+func openConsole(id, ns string, cfg io.ConsoleConfig, logPath string, opts *Options) (_ *io.Console, _ net.Listener, _ *io.LogFile, retErr error) {
+	con := io.NewConsole(cfg)
+
+	history, err := io.ServeHistory(ConsoleHistorySocket(ns, id), con)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	defer func() {
+		if retErr != nil {
+			history.Close()
+		}
+	}()
+
+	consoleLog, err := io.NewLogFile(logPath, opts.ConsoleLogMaxSize, opts.ConsoleLogMaxFiles)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("opening console log: %w", err)
+	}
+	con.Log(consoleLog)
+
+	return con, history, consoleLog, nil
+}
+
+// runInit runs the init process of a task and waits for it in the
+// background; the task exits with it, closing its console and output. A
+// hybrid task also exits with its companion as exitPolicy says.
+func (s *micaTaskService) runInit(ctx context.Context, id, ns string, con *io.Console, output goio.Closer, comp *companion, exitPolicy string) (*exec.Cmd, context.Context, error) {
+	// The RTOS client has no process of its own, the init process only holds
+	// the task's pid and exits when the task is killed.
+	// TODO: replace to mica sender
+	cmd := exec.CommandContext(ctx, initArgs[0], initArgs[1:]...)
+	// it must not outlive the shim, a new one runs its own
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+
+	// the shim reaps all its children, so wait through its reaper
+	ec, err := reaper.Default.Start(cmd)
+	if err != nil {
+		return nil, nil, fmt.Errorf("running init command: %w", err)
+	}
+	pid := cmd.Process.Pid
+
+	doneCtx, markDone := context.WithCancel(context.Background())
+
+	if comp != nil && exitPolicy == exitPolicyAny {
+		go func() {
+			select {
+			case <-comp.done:
+				s.companionExited(id, comp, cmd, doneCtx)
+			case <-doneCtx.Done():
+			}
+		}()
+	}
+
+	go func() {
+		defer markDone()
+
+		exitStatus, err := reaper.Default.Wait(cmd, ec)
+		if err != nil {
+			log.WithError(err).Errorf("failed to wait for init process %d", pid)
+			exitStatus = 255
+		}
+
+		s.m.RLock()
+		if proc, ok := s.procs[id]; ok && proc.lostStatus != 0 {
+			// the client stopped on its own, see watchClient
+			exitStatus = proc.lostStatus
+		}
+		s.m.RUnlock()
+
+		if comp != nil {
+			exitStatus = comp.join(exitPolicy, exitStatus)
+		}
+
+		if err := con.Close(); err != nil {
+			log.WithError(err).Error("failed to close console")
+		}
+		if err := output.Close(); err != nil {
+			log.WithError(err).Error("failed to close task output")
+		}
+
+		exitTime, ok := s.exited(id, exitStatus)
+		if !ok {
+			log.Errorf("failed to write final status of done init process: task was removed")
+			return
+		}
+		s.publish(context.Background(), ns, runtime.TaskExitEventTopic, &eventstypes.TaskExit{
+			ContainerID: id,
+			ID:          id,
+			Pid:         uint32(pid),
+			ExitStatus:  uint32(exitStatus),
+			ExitedAt:    protobuf.ToTimestamp(exitTime),
+		})
+	}()
+
+	return cmd, doneCtx, nil
+}
@@ -20,10 +20,14 @@ package core
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	defs "mica-shim/definitions"
+	micaevents "mica-shim/events"
+	"mica-shim/firmware"
 	"mica-shim/io"
+	"mica-shim/libmica"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"sync"
 	"syscall"
@@ -31,14 +35,19 @@ import (
 
 	log "mica-shim/logger"
 
+	eventstypes "github.com/containerd/containerd/api/events"
 	taskAPI "github.com/containerd/containerd/api/runtime/task/v2"
 	tasktypes "github.com/containerd/containerd/api/types/task"
 
 	"github.com/containerd/containerd/api/services/ttrpc/events/v1"
 	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/namespaces"
 	"github.com/containerd/containerd/protobuf"
 	ptypes "github.com/containerd/containerd/protobuf/types"
+	"github.com/containerd/containerd/runtime"
 	"github.com/containerd/containerd/runtime/v2/shim"
+	"github.com/containerd/typeurl/v2"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
 )
 
 var (
@@ -143,85 +152,200 @@ func (s *micaTaskService) Create(ctx context.Context, r *taskAPI.CreateTaskReque
 		return nil, fmt.Errorf("getting current working directory: %w", err)
 	}
 
-	// TODO: replace to mica sender
-	cmd := exec.CommandContext(ctx, "sh", "-c",
-		"while date --rfc-3339=seconds; do "+
-			"sleep 5; "+
-			"done",
-	)
+	spec, err := readSpec(r.Bundle)
+	if err != nil {
+		return nil, err
+	}
 
-	pio, err := io.NewPipeIO(r.Stdout)
+	opts, err := parseOptions(r.Options)
 	if err != nil {
-		return nil, fmt.Errorf("creating pipe io for stdout %s: %w", r.Stdout, err)
+		return nil, errdefs.ToGRPC(err)
 	}
 
-	go func() {
-		if err := pio.Copy(ctx); err != nil {
-			log.Warn("failed to copy from stdout pipe")
+	// a task restored from a checkpoint gets the client it was taken from
+	var cp *checkpoint
+	if r.Checkpoint != "" {
+		if cp, err = readCheckpoint(r.Checkpoint); err != nil {
+			return nil, errdefs.ToGRPC(err)
+		}
+		cp.apply(spec)
+	}
+
+	rootfs, mounted, err := mountRootfs(r.Bundle, r.Rootfs, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if retErr != nil && mounted {
+			if err := unmountRootfs(rootfs); err != nil {
+				log.WithError(err).Error("failed to unmount rootfs")
+			}
 		}
 	}()
 
-	cmd.Stdout = pio.Writer()
+	ns, _ := namespaces.Namespace(ctx)
+	owner := ns + "/" + r.ID
+
+	hybrid, exitPolicy, err := hybridPolicy(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	// the firmware of a restored task and its signature come from the
+	// checkpoint, which stands for the rootfs for admission
+	fwRoot := rootfs
+	fwPath := filepath.Join(r.Checkpoint, checkpointFirmware)
+	if cp != nil {
+		fwRoot = r.Checkpoint
+	} else if fwPath, err = resolveFirmware(rootfs, spec); err != nil {
+		return nil, err
+	}
 
-	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("running init command: %w", err)
+	// micad reads the firmware from the store, which outlives the rootfs
+	// mounts and keeps the path short.
+	image, err := s.store.Add(fwPath, owner)
+	if err != nil {
+		return nil, errdefs.ToGRPC(fmt.Errorf("storing firmware %s: %w", fwPath, err))
+	}
+	if image.Compression != firmware.CompressionNone {
+		log.Infof("decompressed %s firmware %s", image.Compression, fwPath)
 	}
 
 	defer func() {
 		if retErr != nil {
-			if err := pio.Close(); err != nil {
-				log.LocateDebugf("pid = %v, err = %v", cmd.Process.Pid, err)
-				log.Error("failed to close stdout pipe io")
-			}
-			if err := cmd.Cancel(); err != nil {
-				log.LocateDebugf("pid = %v, err = %v", cmd.Process.Pid, err)
-				log.Error("failed to cancel task init command")
+			if err := s.store.Release(image.Digest, owner); err != nil {
+				log.WithError(err).Errorf("failed to release firmware %s", image.Digest)
 			}
 		}
 	}()
 
-	pid := cmd.Process.Pid
+	if cp != nil && image.Digest != cp.Firmware {
+		return nil, fmt.Errorf("checkpoint firmware is %s, expected %s: %w",
+			image.Digest, cp.Firmware, errdefs.ErrInvalidArgument)
+	}
+
+	// only admitted firmware gets parsed
+	signature, err := admitFirmware(r.ID, fwRoot, fwPath, image)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := firmware.Validate(image.Path)
+	if err != nil {
+		return nil, errdefs.ToGRPC(fmt.Errorf("validating firmware %s: %w", fwPath, err))
+	}
+	log.Infof("task %s uses firmware %s (build-id %q, entry 0x%x, %d segments)",
+		r.ID, image.Digest, meta.BuildID, meta.Entry, len(meta.Segments))
+
+	if len(image.Path) >= libmica.MaxPathLen {
+		return nil, fmt.Errorf("firmware path %s is longer than %d bytes: %w",
+			image.Path, libmica.MaxPathLen-1, errdefs.ErrInvalidArgument)
+	}
+
+	var cpu uint32
+	if cp != nil {
+		cpu = cp.Create.CPU
+	} else if cpu, err = clientCPU(spec); err != nil {
+		return nil, err
+	}
+
+	sh, execTimeout, err := execShell(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	restart, err := taskRestartPolicy(spec)
+	if err != nil {
+		return nil, err
+	}
 
-	doneCtx, markDone := context.WithCancel(context.Background())
+	wd, err := taskWatchdog(spec)
+	if err != nil {
+		return nil, err
+	}
 
-	go func() {
-		defer markDone()
+	client := clientName(ns, r.ID)
+	log.Infof("creating mica client %s on cpu %d with firmware %s", client, cpu, image.Path)
+	if _, err := libmica.MicaCreate(libmica.NewMicaCreateMsg(cpu, client, image.Path, "", "", false)); err != nil {
+		return nil, fmt.Errorf("creating mica client %s: %w", client, err)
+	}
 
-		if err := cmd.Wait(); err != nil {
-			if _, ok := err.(*exec.ExitError); !ok {
-				log.Errorf("failed to wait for init process %d", pid)
+	defer func() {
+		if retErr != nil {
+			if _, err := libmica.MicaCtl(libmica.MRemove, client); err != nil {
+				log.WithError(err).Errorf("failed to remove mica client %s", client)
 			}
 		}
+	}()
 
-		if err := pio.Close(); err != nil {
-			log.Error("failed to close stdout pipe io")
+	// the output of the task is opened once, for its console and companion
+	stdout, stderr, output, err := io.Output{
+		ID:        r.ID,
+		Namespace: ns,
+		Stdout:    r.Stdout,
+		Stderr:    r.Stderr,
+	}.Open(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("opening stdio of task %s: %w", r.ID, err)
+	}
+
+	defer func() {
+		if retErr != nil {
+			output.Close()
 		}
+	}()
 
-		exitStatus := 255
+	con, history, consoleLog, err := openConsole(r.ID, ns, io.ConsoleConfig{
+		Stdin:        r.Stdin,
+		Stdout:       stdout,
+		Stderr:       stderr,
+		Terminal:     r.Terminal,
+		ResizeEscape: spec.Annotations[defs.AnnotationResizeEscape] == "true",
+	}, opts.consoleLogPath(r.Bundle, ns, r.ID), opts)
+	if err != nil {
+		return nil, err
+	}
 
-		if cmd.ProcessState != nil {
-			switch unixWaitStatus := cmd.ProcessState.Sys().(syscall.WaitStatus); {
-			case cmd.ProcessState.Exited():
-				exitStatus = cmd.ProcessState.ExitCode()
-			case unixWaitStatus.Signaled():
-				exitStatus = exitCodeSignal + int(unixWaitStatus.Signal())
-			}
-		} else {
-			log.Warn("init process wait returned without setting process state")
+	defer func() {
+		if retErr != nil {
+			history.Close()
+			consoleLog.Close()
 		}
+	}()
 
-		s.m.Lock()
-		defer s.m.Unlock()
-
-		proc, ok := s.procs[r.ID]
-		if !ok {
-			log.Errorf("failed to write final status of done init process: task was removed")
+	var comp *companion
+	if hybrid {
+		comp, err = createCompanion(ctx, r.ID, ns, r.Bundle, stdout, stderr)
+		if err != nil {
+			return nil, err
 		}
 
-		proc.exitTime = time.Now()
-		proc.exitStatus = exitStatus
+		defer func() {
+			if retErr != nil {
+				if err := comp.delete(context.Background()); err != nil {
+					log.WithError(err).Error("failed to delete companion")
+				}
+			}
+		}()
+	}
+
+	cmd, doneCtx, err := s.runInit(ctx, r.ID, ns, con, output, comp, exitPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if retErr != nil {
+			if err := cmd.Cancel(); err != nil {
+				log.LocateDebugf("pid = %v, err = %v", cmd.Process.Pid, err)
+				log.Error("failed to cancel task init command")
+			}
+		}
 	}()
 
+	pid := cmd.Process.Pid
+
 	// If containerd needs to resort to calling the shim's "delete" command to
 	// clean things up, having the process' pid readable from a file is the
 	// only way for it to know what init process is associated with the task.
@@ -230,11 +354,38 @@ func (s *micaTaskService) Create(ctx context.Context, r *taskAPI.CreateTaskReque
 		return nil, fmt.Errorf("writing pid file of init process: %w", err)
 	}
 
-	s.procs[r.ID] = &initProcess{
-		pid:     pid,
-		doneCtx: doneCtx,
-		stdout:  r.Stdout,
+	proc := &initProcess{
+		id:            r.ID,
+		pid:           pid,
+		doneCtx:       doneCtx,
+		stdin:         r.Stdin,
+		stdout:        r.Stdout,
+		stderr:        r.Stderr,
+		terminal:      r.Terminal,
+		console:       con,
+		consoleDevice: spec.Annotations[defs.AnnotationConsole],
+		logDevice:     spec.Annotations[defs.AnnotationLogConsole],
+		history:       history,
+		consoleLog:    consoleLog,
+		options:       opts,
+		shell:         sh,
+		execTimeout:   execTimeout,
+		restart:       restart,
+		watchdog:      wd,
+		execs:         make(map[string]*execProcess),
+		companion:     comp,
+		bundle:        r.Bundle,
+		rootfs:        rootfs,
+		rootfsMounted: mounted,
+		client:        client,
+		cpu:           cpu,
+		image:         image,
+		signature:     signature,
+		meta:          meta,
+		namespace:     ns,
 	}
+	s.procs[r.ID] = proc
+	proc.journal()
 
 	return &taskAPI.CreateTaskResponse{
 		Pid: uint32(pid),
@@ -245,16 +396,42 @@ func (s *micaTaskService) Create(ctx context.Context, r *taskAPI.CreateTaskReque
 func (s *micaTaskService) Start(ctx context.Context, r *taskAPI.StartRequest) (*taskAPI.StartResponse, error) {
 	log.Debugf("start id:%s execid:%s", r.ID, r.ExecID)
 
+	if r.ExecID != "" {
+		if err := s.startExec(r.ID, r.ExecID); err != nil {
+			return nil, err
+		}
+		return &taskAPI.StartResponse{}, nil
+	}
+
 	// we do not support starting a previously stopped task, and the init
 	// process was already started inside the Create RPC call, so we naively
 	// return its stored PID
-	s.m.RLock()
-	defer s.m.RUnlock()
+	s.m.Lock()
+	defer s.m.Unlock()
 	proc, ok := s.procs[r.ID]
 	if !ok {
 		return nil, fmt.Errorf("task not created: %w", errdefs.ErrNotFound)
 	}
 
+	if err := proc.micaCtl(libmica.MStart); err != nil {
+		return nil, fmt.Errorf("starting mica client %s: %w", proc.client, err)
+	}
+	proc.startedAt = time.Now()
+	proc.journal()
+
+	go s.watchClient(r.ID, proc)
+	if proc.watchdog != nil {
+		go s.watchHeartbeat(r.ID, proc)
+	}
+
+	go s.attachConsole(r.ID, proc)
+
+	if proc.companion != nil {
+		if err := proc.companion.start(ctx); err != nil {
+			return nil, err
+		}
+	}
+
 	return &taskAPI.StartResponse{
 		Pid: uint32(proc.pid),
 	}, nil
@@ -264,6 +441,10 @@ func (s *micaTaskService) Start(ctx context.Context, r *taskAPI.StartRequest) (*
 func (s *micaTaskService) Delete(ctx context.Context, r *taskAPI.DeleteRequest) (*taskAPI.DeleteResponse, error) {
 	log.Debugf("delete id:%s execid:%s", r.ID, r.ExecID)
 
+	if r.ExecID != "" {
+		return s.deleteExec(r.ID, r.ExecID)
+	}
+
 	s.m.Lock()
 	defer s.m.Unlock()
 	proc, ok := s.procs[r.ID]
@@ -275,6 +456,38 @@ func (s *micaTaskService) Delete(ctx context.Context, r *taskAPI.DeleteRequest)
 		return nil, errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "init process %d is not done yet", proc.pid)
 	}
 
+	if err := proc.micaCtl(libmica.MRemove); err != nil {
+		log.WithError(err).Warnf("failed to remove mica client %s", proc.client)
+	}
+
+	if proc.companion != nil {
+		if err := proc.companion.delete(ctx); err != nil {
+			log.WithError(err).Warnf("failed to delete companion of task %s", r.ID)
+		}
+	}
+
+	if err := proc.history.Close(); err != nil {
+		log.WithError(err).Warn("failed to close console history socket")
+	}
+
+	if err := proc.consoleLog.Close(); err != nil {
+		log.WithError(err).Warn("failed to close console log")
+	}
+
+	if proc.rootfsMounted {
+		if err := unmountRootfs(proc.rootfs); err != nil {
+			log.WithError(err).Warn("failed to unmount rootfs")
+		}
+	}
+
+	if err := s.store.Release(proc.image.Digest, proc.namespace+"/"+r.ID); err != nil {
+		log.WithError(err).Warnf("failed to release firmware %s", proc.image.Digest)
+	}
+
+	if err := removeState(proc.bundle); err != nil {
+		log.WithError(err).Warnf("failed to remove state of task %s", r.ID)
+	}
+
 	delete(s.procs, r.ID)
 
 	return &taskAPI.DeleteResponse{
@@ -284,22 +497,69 @@ func (s *micaTaskService) Delete(ctx context.Context, r *taskAPI.DeleteRequest)
 	}, nil
 }
 
-// Exec executes an additional process inside the task.
-func (*micaTaskService) Exec(ctx context.Context, r *taskAPI.ExecProcessRequest) (*ptypes.Empty, error) {
+// Exec executes an additional process inside the task. The process.args of
+// the exec process are run as a command line in the RTOS shell.
+func (s *micaTaskService) Exec(ctx context.Context, r *taskAPI.ExecProcessRequest) (*ptypes.Empty, error) {
 	log.Debugf("exec id:%s execid:%s", r.ID, r.ExecID)
-	return nil, errdefs.ErrNotImplemented
+
+	ep, err := newExecProcess(r)
+	if err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	proc, ok := s.procs[r.ID]
+	if !ok {
+		return nil, fmt.Errorf("task not created: %w", errdefs.ErrNotFound)
+	}
+	if _, ok := proc.execs[r.ExecID]; ok {
+		return nil, errdefs.ToGRPCf(errdefs.ErrAlreadyExists, "exec %s", r.ExecID)
+	}
+	if !proc.exitTime.IsZero() {
+		return nil, errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "task %s is not running", r.ID)
+	}
+
+	proc.execs[r.ExecID] = ep
+
+	return &ptypes.Empty{}, nil
 }
 
 // ResizePty resizes the pty of a process.
-func (*micaTaskService) ResizePty(ctx context.Context, r *taskAPI.ResizePtyRequest) (*ptypes.Empty, error) {
+func (s *micaTaskService) ResizePty(ctx context.Context, r *taskAPI.ResizePtyRequest) (*ptypes.Empty, error) {
 	log.Debugf("resizepty id:%s execid:%s", r.ID, r.ExecID)
-	return nil, errdefs.ErrNotImplemented
+
+	// exec processes only get the output of a command, even with a terminal
+	if r.ExecID != "" {
+		return &ptypes.Empty{}, nil
+	}
+
+	s.m.RLock()
+	defer s.m.RUnlock()
+	proc, ok := s.procs[r.ID]
+	if !ok {
+		return nil, fmt.Errorf("task not created: %w", errdefs.ErrNotFound)
+	}
+
+	if !proc.terminal {
+		return nil, errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "task %s has no terminal", r.ID)
+	}
+
+	if err := proc.console.Resize(r.Width, r.Height); err != nil {
+		return nil, fmt.Errorf("resizing terminal of task %s: %w", r.ID, err)
+	}
+
+	return &ptypes.Empty{}, nil
 }
 
 // State returns the runtime state of a process.
 func (s *micaTaskService) State(ctx context.Context, r *taskAPI.StateRequest) (*taskAPI.StateResponse, error) {
 	log.Debugf("state id:%s execid:%s", r.ID, r.ExecID)
 
+	if r.ExecID != "" {
+		return s.execState(r.ID, r.ExecID)
+	}
+
 	s.m.RLock()
 	defer s.m.RUnlock()
 	proc, ok := s.procs[r.ID]
@@ -308,36 +568,65 @@ func (s *micaTaskService) State(ctx context.Context, r *taskAPI.StateRequest) (*
 	}
 
 	status := tasktypes.Status_RUNNING
-	if !proc.exitTime.IsZero() {
+	switch {
+	case !proc.exitTime.IsZero():
 		status = tasktypes.Status_STOPPED
+	case proc.paused:
+		status = tasktypes.Status_PAUSED
 	}
 
 	return &taskAPI.StateResponse{
 		ID:         r.ID,
 		Pid:        uint32(proc.pid),
 		Status:     status,
+		Stdin:      proc.stdin,
 		Stdout:     proc.stdout,
+		Stderr:     proc.stderr,
+		Terminal:   proc.terminal,
 		ExitStatus: uint32(proc.exitStatus),
 		ExitedAt:   protobuf.ToTimestamp(proc.exitTime),
 	}, nil
 }
 
 // Pause pauses a task.
-func (*micaTaskService) Pause(ctx context.Context, r *taskAPI.PauseRequest) (*ptypes.Empty, error) {
+func (s *micaTaskService) Pause(ctx context.Context, r *taskAPI.PauseRequest) (*ptypes.Empty, error) {
 	log.Debugf("pause id:%s", r.ID)
-	return nil, errdefs.ErrNotImplemented
+
+	ns, err := s.pauseTask(ctx, r.ID)
+	if err != nil {
+		return nil, err
+	}
+	s.publish(ctx, ns, runtime.TaskPausedEventTopic, &eventstypes.TaskPaused{
+		ContainerID: r.ID,
+	})
+	return &ptypes.Empty{}, nil
 }
 
 // Resume resumes a task.
-func (*micaTaskService) Resume(ctx context.Context, r *taskAPI.ResumeRequest) (*ptypes.Empty, error) {
+func (s *micaTaskService) Resume(ctx context.Context, r *taskAPI.ResumeRequest) (*ptypes.Empty, error) {
 	log.Debugf("resume id:%s", r.ID)
-	return nil, errdefs.ErrNotImplemented
+
+	ns, err := s.resumeTask(ctx, r.ID)
+	if err != nil {
+		return nil, err
+	}
+	s.publish(ctx, ns, runtime.TaskResumedEventTopic, &eventstypes.TaskResumed{
+		ContainerID: r.ID,
+	})
+	return &ptypes.Empty{}, nil
 }
 
 // Kill kills a process.
 func (s *micaTaskService) Kill(ctx context.Context, r *taskAPI.KillRequest) (*ptypes.Empty, error) {
 	log.Debugf("kill id:%s execid:%s", r.ID, r.ExecID)
 
+	if r.ExecID != "" {
+		if err := s.killExec(r.ID, r.ExecID, syscall.Signal(r.Signal)); err != nil {
+			return nil, err
+		}
+		return &ptypes.Empty{}, nil
+	}
+
 	s.m.RLock()
 	defer s.m.RUnlock()
 	proc, ok := s.procs[r.ID]
@@ -345,12 +634,32 @@ func (s *micaTaskService) Kill(ctx context.Context, r *taskAPI.KillRequest) (*pt
 		return nil, fmt.Errorf("task not created: %w", errdefs.ErrNotFound)
 	}
 
+	sig := syscall.Signal(r.Signal)
+	if proc.companion != nil {
+		if err := proc.companion.kill(ctx, sig); err != nil {
+			log.WithError(err).Warnf("failed to kill companion of task %s", r.ID)
+		}
+	}
+
+	// the RTOS has no signals, it can only be stopped; any other signal is
+	// for the companion of a hybrid task
+	if !stopsTask(sig) {
+		if proc.companion != nil {
+			return &ptypes.Empty{}, nil
+		}
+		return nil, errdefs.ToGRPCf(errdefs.ErrNotImplemented, "sending %s to task %s", sig, r.ID)
+	}
+
+	proc.stopping.Store(true)
+	if err := proc.micaCtl(libmica.MStop); err != nil {
+		log.WithError(err).Warnf("failed to stop mica client %s", proc.client)
+	}
+
 	if proc.pid > 0 {
 		p, _ := os.FindProcess(proc.pid)
 		// The POSIX standard specifies that a null-signal can be sent to check
 		// whether a PID is valid.
 		if err := p.Signal(syscall.Signal(0)); err == nil {
-			sig := syscall.Signal(r.Signal)
 			if err := p.Signal(sig); err != nil {
 				return nil, fmt.Errorf("sending %s to init process: %w", sig, err)
 			}
@@ -360,6 +669,16 @@ func (s *micaTaskService) Kill(ctx context.Context, r *taskAPI.KillRequest) (*pt
 	return &ptypes.Empty{}, nil
 }
 
+// stopsTask tells whether sig is one containers are stopped with, which
+// stops the RTOS of a task.
+func stopsTask(sig syscall.Signal) bool {
+	switch sig {
+	case syscall.SIGKILL, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT:
+		return true
+	}
+	return false
+}
+
 // Pids returns all pids inside a task.
 func (s *micaTaskService) Pids(ctx context.Context, r *taskAPI.PidsRequest) (*taskAPI.PidsResponse, error) {
 	log.Debugf("pids id:%s", r.ID)
@@ -367,15 +686,59 @@ func (s *micaTaskService) Pids(ctx context.Context, r *taskAPI.PidsRequest) (*ta
 }
 
 // CloseIO closes the I/O of a process.
-func (*micaTaskService) CloseIO(ctx context.Context, r *taskAPI.CloseIORequest) (*ptypes.Empty, error) {
+func (s *micaTaskService) CloseIO(ctx context.Context, r *taskAPI.CloseIORequest) (*ptypes.Empty, error) {
 	log.Debugf("closeio id:%s execid:%s", r.ID, r.ExecID)
-	return nil, errdefs.ErrNotImplemented
+
+	// exec processes do not read their stdin
+	if r.ExecID != "" {
+		return &ptypes.Empty{}, nil
+	}
+
+	s.m.RLock()
+	defer s.m.RUnlock()
+	proc, ok := s.procs[r.ID]
+	if !ok {
+		return nil, fmt.Errorf("task not created: %w", errdefs.ErrNotFound)
+	}
+
+	if r.Stdin {
+		if err := proc.console.CloseStdin(); err != nil {
+			return nil, fmt.Errorf("closing stdin of task %s: %w", r.ID, err)
+		}
+	}
+
+	return &ptypes.Empty{}, nil
 }
 
-// Checkpoint creates a checkpoint of a task.
-func (*micaTaskService) Checkpoint(ctx context.Context, r *taskAPI.CheckpointTaskRequest) (*ptypes.Empty, error) {
+// Checkpoint creates a checkpoint of a task: its firmware, the create message
+// of its client, its annotations and, where possible, a dump of the RTOS
+// memory. The task keeps running.
+func (s *micaTaskService) Checkpoint(ctx context.Context, r *taskAPI.CheckpointTaskRequest) (*ptypes.Empty, error) {
 	log.Debugf("checkpoint id:%s", r.ID)
-	return nil, errdefs.ErrNotImplemented
+
+	s.m.RLock()
+	proc, ok := s.procs[r.ID]
+	if !ok {
+		s.m.RUnlock()
+		return nil, fmt.Errorf("task not created: %w", errdefs.ErrNotFound)
+	}
+	if !proc.exitTime.IsZero() {
+		s.m.RUnlock()
+		return nil, errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "task %s is not running", r.ID)
+	}
+	// copying the firmware and the memory takes its time, not under s.m
+	src := proc.checkpointSource()
+	s.m.RUnlock()
+
+	spec, err := readSpec(src.bundle)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeCheckpoint(r.Path, src, spec); err != nil {
+		return nil, fmt.Errorf("checkpointing task %s: %w", r.ID, err)
+	}
+
+	return &ptypes.Empty{}, nil
 }
 
 // Connect returns the shim information of the underlying service.
@@ -403,22 +766,63 @@ func (s *micaTaskService) Shutdown(ctx context.Context, r *taskAPI.ShutdownReque
 	return &ptypes.Empty{}, nil
 }
 
-// Stats returns container level system stats for a task and its processes.
-func (*micaTaskService) Stats(ctx context.Context, r *taskAPI.StatsRequest) (*taskAPI.StatsResponse, error) {
+// Stats returns the metrics of a task, as a metrics.Metrics.
+func (s *micaTaskService) Stats(ctx context.Context, r *taskAPI.StatsRequest) (*taskAPI.StatsResponse, error) {
 	log.Debugf("stats id:%s", r.ID)
-	return nil, errdefs.ErrNotImplemented
+
+	s.m.RLock()
+	defer s.m.RUnlock()
+	proc, ok := s.procs[r.ID]
+	if !ok {
+		return nil, fmt.Errorf("task not created: %w", errdefs.ErrNotFound)
+	}
+
+	stats, err := typeurl.MarshalAny(proc.metrics())
+	if err != nil {
+		return nil, fmt.Errorf("encoding metrics of task %s: %w", r.ID, err)
+	}
+	return &taskAPI.StatsResponse{
+		Stats: protobuf.FromAny(stats),
+	}, nil
 }
 
-// Update updates the live task.
-func (*micaTaskService) Update(ctx context.Context, r *taskAPI.UpdateTaskRequest) (*ptypes.Empty, error) {
+// Update updates the live task. A firmware annotation replaces the firmware
+// of the RTOS, and new resources apply to the companion of a hybrid task and
+// move the RTOS out of a cpuset it is no longer part of.
+func (s *micaTaskService) Update(ctx context.Context, r *taskAPI.UpdateTaskRequest) (*ptypes.Empty, error) {
 	log.Debugf("update id:%s", r.ID)
-	return nil, errdefs.ErrNotImplemented
+
+	if r.Annotations[defs.AnnotationFirmware] != "" {
+		ns, event, err := s.replaceFirmware(r.ID, r.Annotations)
+		if event != nil {
+			s.publish(ctx, ns, micaevents.TopicFirmwareUpdate, event)
+		}
+		if err != nil {
+			return nil, errdefs.ToGRPC(err)
+		}
+	}
+
+	if r.Resources != nil {
+		var resources specs.LinuxResources
+		if err := json.Unmarshal(r.Resources.GetValue(), &resources); err != nil {
+			return nil, errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "decoding resources: %v", err)
+		}
+		if err := s.updateResources(ctx, r.ID, &resources); err != nil {
+			return nil, errdefs.ToGRPC(err)
+		}
+	}
+
+	return &ptypes.Empty{}, nil
 }
 
 // Wait waits for a process to exit while attached to a task.
 func (s *micaTaskService) Wait(ctx context.Context, r *taskAPI.WaitRequest) (*taskAPI.WaitResponse, error) {
 	log.Debugf("wait id:%s execid:%s", r.ID, r.ExecID)
 
+	if r.ExecID != "" {
+		return s.waitExec(ctx, r.ID, r.ExecID)
+	}
+
 	doneCtx, err := func() (context.Context, error) {
 		s.m.RLock()
 		defer s.m.RUnlock()
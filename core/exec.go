@@ -0,0 +1,273 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"syscall"
+	"time"
+
+	defs "mica-shim/definitions"
+	"mica-shim/io"
+	log "mica-shim/logger"
+
+	taskAPI "github.com/containerd/containerd/api/runtime/task/v2"
+	tasktypes "github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/protobuf"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// defaultShellTimeout bounds how long an exec process waits for the RTOS
+// shell to prompt again, unless set by AnnotationShellTimeout.
+const defaultShellTimeout = 30 * time.Second
+
+// execProcess is an exec process of a task: a command line run in the RTOS
+// shell, whose output goes to the stdout of the exec process.
+type execProcess struct {
+	args     []string
+	stdin    string
+	stdout   string
+	stderr   string
+	terminal bool
+
+	// cancel interrupts the command once it is started, and doneCtx is done
+	// once it returned.
+	cancel     context.CancelFunc
+	killSignal syscall.Signal
+	doneCtx    context.Context
+	exitTime   time.Time
+	exitStatus int
+}
+
+// execShell returns the RTOS shell of a task and the timeout of its exec
+// processes, as set by annotations.
+func execShell(spec *specs.Spec) (io.Shell, time.Duration, error) {
+	sh := io.Shell{StatusCommand: spec.Annotations[defs.AnnotationShellStatus]}
+	if p := spec.Annotations[defs.AnnotationShellPrompt]; p != "" {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return sh, 0, fmt.Errorf("invalid %s annotation: %v: %w", defs.AnnotationShellPrompt, err, errdefs.ErrInvalidArgument)
+		}
+		sh.Prompt = re
+	}
+
+	timeout := defaultShellTimeout
+	if t := spec.Annotations[defs.AnnotationShellTimeout]; t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil || d <= 0 {
+			return sh, 0, fmt.Errorf("invalid %s annotation %q: %w", defs.AnnotationShellTimeout, t, errdefs.ErrInvalidArgument)
+		}
+		timeout = d
+	}
+	return sh, timeout, nil
+}
+
+// newExecProcess returns the exec process described by an Exec request.
+func newExecProcess(r *taskAPI.ExecProcessRequest) (*execProcess, error) {
+	if r.Spec == nil {
+		return nil, fmt.Errorf("no process spec: %w", errdefs.ErrInvalidArgument)
+	}
+	var spec specs.Process
+	if err := json.Unmarshal(r.Spec.GetValue(), &spec); err != nil {
+		return nil, fmt.Errorf("decoding process spec: %v: %w", err, errdefs.ErrInvalidArgument)
+	}
+	if len(spec.Args) == 0 {
+		return nil, fmt.Errorf("no command line in process.args: %w", errdefs.ErrInvalidArgument)
+	}
+
+	return &execProcess{
+		args:     spec.Args,
+		stdin:    r.Stdin,
+		stdout:   r.Stdout,
+		stderr:   r.Stderr,
+		terminal: r.Terminal,
+	}, nil
+}
+
+// getExec returns an exec process of a task. s.m must be held.
+func (s *micaTaskService) getExec(id, execID string) (*initProcess, *execProcess, error) {
+	proc, ok := s.procs[id]
+	if !ok {
+		return nil, nil, fmt.Errorf("task not created: %w", errdefs.ErrNotFound)
+	}
+	ep, ok := proc.execs[execID]
+	if !ok {
+		return nil, nil, fmt.Errorf("exec %s not created: %w", execID, errdefs.ErrNotFound)
+	}
+	return proc, ep, nil
+}
+
+// startExec runs an exec process in the RTOS shell of its task.
+func (s *micaTaskService) startExec(id, execID string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	proc, ep, err := s.getExec(id, execID)
+	if err != nil {
+		return err
+	}
+	if ep.doneCtx != nil {
+		return errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "exec %s already started", execID)
+	}
+	if !proc.exitTime.IsZero() || proc.paused {
+		return errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "task %s is not running", id)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), proc.execTimeout)
+	doneCtx, markDone := context.WithCancel(context.Background())
+	ep.cancel, ep.doneCtx = cancel, doneCtx
+
+	go func() {
+		defer markDone()
+		defer cancel()
+
+		status := s.runExec(ctx, proc, ep, execID)
+
+		s.m.Lock()
+		defer s.m.Unlock()
+		if ep.killSignal != 0 {
+			status = exitCodeSignal + int(ep.killSignal)
+		}
+		ep.exitTime = time.Now()
+		ep.exitStatus = status
+	}()
+
+	return nil
+}
+
+// runExec runs an exec process and returns its exit status.
+func (s *micaTaskService) runExec(ctx context.Context, proc *initProcess, ep *execProcess, execID string) int {
+	out, _, closer, err := io.Output{
+		ID:        execID,
+		Namespace: proc.namespace,
+		Stdout:    ep.stdout,
+		Stderr:    ep.stderr,
+	}.Open(ctx)
+	if err != nil {
+		log.WithError(err).Warnf("failed to open stdio of exec %s", execID)
+		return exitCodeSignal + int(syscall.SIGKILL)
+	}
+	defer closer.Close()
+
+	output, status, err := proc.console.Run(ctx, proc.shell, ep.args)
+	if _, werr := out.Write(output); werr != nil {
+		log.WithError(werr).Warnf("failed to write output of exec %s", execID)
+	}
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			log.Warnf("exec %s timed out waiting for the shell prompt", execID)
+		} else if !errors.Is(err, context.Canceled) {
+			log.WithError(err).Warnf("failed to run exec %s", execID)
+		}
+		return exitCodeSignal + int(syscall.SIGKILL)
+	}
+	return status
+}
+
+// execState returns the runtime state of an exec process.
+func (s *micaTaskService) execState(id, execID string) (*taskAPI.StateResponse, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	_, ep, err := s.getExec(id, execID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := tasktypes.Status_CREATED
+	switch {
+	case !ep.exitTime.IsZero():
+		status = tasktypes.Status_STOPPED
+	case ep.doneCtx != nil:
+		status = tasktypes.Status_RUNNING
+	}
+
+	return &taskAPI.StateResponse{
+		ID:         id,
+		ExecID:     execID,
+		Status:     status,
+		Stdin:      ep.stdin,
+		Stdout:     ep.stdout,
+		Stderr:     ep.stderr,
+		Terminal:   ep.terminal,
+		ExitStatus: uint32(ep.exitStatus),
+		ExitedAt:   protobuf.ToTimestamp(ep.exitTime),
+	}, nil
+}
+
+// killExec interrupts the command of an exec process; whatever the signal,
+// the shell gets ^C.
+func (s *micaTaskService) killExec(id, execID string, sig syscall.Signal) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	_, ep, err := s.getExec(id, execID)
+	if err != nil {
+		return err
+	}
+	if ep.doneCtx == nil {
+		return errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "exec %s not started", execID)
+	}
+	if ep.exitTime.IsZero() {
+		ep.killSignal = sig
+		ep.cancel()
+	}
+	return nil
+}
+
+// waitExec waits for an exec process to exit.
+func (s *micaTaskService) waitExec(ctx context.Context, id, execID string) (*taskAPI.WaitResponse, error) {
+	doneCtx, err := func() (context.Context, error) {
+		s.m.RLock()
+		defer s.m.RUnlock()
+		_, ep, err := s.getExec(id, execID)
+		if err != nil {
+			return nil, err
+		}
+		if ep.doneCtx == nil {
+			return nil, errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "exec %s not started", execID)
+		}
+		return ep.doneCtx, nil
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-doneCtx.Done():
+	}
+
+	s.m.RLock()
+	defer s.m.RUnlock()
+	_, ep, err := s.getExec(id, execID)
+	if err != nil {
+		return nil, fmt.Errorf("exec was removed: %w", err)
+	}
+
+	return &taskAPI.WaitResponse{
+		ExitStatus: uint32(ep.exitStatus),
+		ExitedAt:   protobuf.ToTimestamp(ep.exitTime),
+	}, nil
+}
+
+// deleteExec removes an exec process that is not running.
+func (s *micaTaskService) deleteExec(id, execID string) (*taskAPI.DeleteResponse, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	proc, ep, err := s.getExec(id, execID)
+	if err != nil {
+		return nil, err
+	}
+	if ep.doneCtx != nil && ep.exitTime.IsZero() {
+		return nil, errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "exec %s is still running", execID)
+	}
+
+	delete(proc.execs, execID)
+
+	return &taskAPI.DeleteResponse{
+		ExitStatus: uint32(ep.exitStatus),
+		ExitedAt:   protobuf.ToTimestamp(ep.exitTime),
+	}, nil
+}
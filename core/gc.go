@@ -0,0 +1,158 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	defs "mica-shim/definitions"
+	"mica-shim/firmware"
+	"mica-shim/libmica"
+
+	"github.com/containerd/containerd/runtime/v2/shim"
+)
+
+// DefaultBundleRoot is the directory containerd keeps the bundles of shim v2
+// tasks in, by namespace.
+const DefaultBundleRoot = "/run/containerd/io.containerd.runtime.v2.task"
+
+// OrphanClients returns the micad clients the shim created for a task that
+// is gone, e.g. left behind by a shim that crashed. Clients the shim cannot
+// tell it created, such as ones made with the mica tool, are left alone.
+func OrphanClients(root string) ([]string, error) {
+	clients, err := libmica.ListClients()
+	if err != nil {
+		return nil, fmt.Errorf("listing mica clients: %w", err)
+	}
+	store, err := firmware.NewStore(defs.FirmwareStoreDir)
+	if err != nil {
+		return nil, err
+	}
+	owners, err := store.Owners()
+	if err != nil {
+		return nil, fmt.Errorf("listing firmware owners: %w", err)
+	}
+	return orphanClients(root, clients, owners)
+}
+
+// orphanClients returns the clients created by the shim that no live task of
+// the bundles under root owns. The shim created the clients of the tasks
+// holding a firmware reference, owners as "<namespace>/<id>", and of the
+// tasks journaled in the bundles.
+func orphanClients(root string, clients, owners []string) ([]string, error) {
+	// an unreadable root would make every client look orphaned
+	if _, err := os.Stat(root); err != nil {
+		return nil, fmt.Errorf("reading bundles: %w", err)
+	}
+	bundles, err := filepath.Glob(filepath.Join(root, "*", "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	created := make(map[string]bool)
+	for _, owner := range owners {
+		if ns, id, ok := strings.Cut(owner, "/"); ok {
+			created[clientName(ns, id)] = true
+		}
+	}
+
+	owned := make(map[string]bool)
+	for _, bundle := range bundles {
+		alive := taskAlive(bundle)
+		if alive {
+			owned[clientName(filepath.Base(filepath.Dir(bundle)), filepath.Base(bundle))] = true
+		}
+		if st, err := readState(bundle); err == nil {
+			created[st.Client] = true
+			owned[st.Client] = owned[st.Client] || alive
+		}
+	}
+
+	var orphans []string
+	for _, client := range clients {
+		if created[client] && !owned[client] {
+			orphans = append(orphans, client)
+		}
+	}
+	sort.Strings(orphans)
+	return orphans, nil
+}
+
+// OrphanOwners returns the owners of firmware in the store, tasks as
+// "<namespace>/<id>", that are gone without releasing it, e.g. with a shim
+// that crashed.
+func OrphanOwners(root string) ([]string, error) {
+	store, err := firmware.NewStore(defs.FirmwareStoreDir)
+	if err != nil {
+		return nil, err
+	}
+	owners, err := store.Owners()
+	if err != nil {
+		return nil, fmt.Errorf("listing firmware owners: %w", err)
+	}
+	return orphanOwners(root, owners)
+}
+
+// orphanOwners returns the owners that no live task of the bundles under
+// root is.
+func orphanOwners(root string, owners []string) ([]string, error) {
+	// an unreadable root would make every owner look orphaned
+	if _, err := os.Stat(root); err != nil {
+		return nil, fmt.Errorf("reading bundles: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	var orphans []string
+	for _, owner := range owners {
+		ns, id, ok := strings.Cut(owner, "/")
+		if !ok || seen[owner] {
+			continue
+		}
+		seen[owner] = true
+		if !taskAlive(filepath.Join(root, ns, id)) {
+			orphans = append(orphans, owner)
+		}
+	}
+	sort.Strings(orphans)
+	return orphans, nil
+}
+
+// ReleaseOwner releases the firmware an orphaned owner holds in the store.
+func ReleaseOwner(owner string) error {
+	store, err := firmware.NewStore(defs.FirmwareStoreDir)
+	if err != nil {
+		return err
+	}
+	if err := store.ReleaseOwner(owner); err != nil {
+		return fmt.Errorf("releasing firmware of %s: %w", owner, err)
+	}
+	return nil
+}
+
+// taskAlive tells whether a shim still runs the task of bundle: its init
+// process is alive or its shim answers at the address it wrote. A task being
+// created has no init process yet, but its shim runs.
+func taskAlive(bundle string) bool {
+	if pid, err := readPidFile(filepath.Join(bundle, initPidFile)); err == nil && pid > 0 {
+		if err := syscall.Kill(pid, 0); err == nil || errors.Is(err, syscall.EPERM) {
+			return true
+		}
+	}
+	addr, err := shim.ReadAddress(filepath.Join(bundle, addressFile))
+	return err == nil && shim.CanConnect(addr)
+}
+
+// RemoveClient stops and removes an orphaned micad client, which releases
+// its core.
+func RemoveClient(client string) error {
+	// the client may not be running
+	libmica.MicaCtl(libmica.MStop, client)
+	if _, err := libmica.MicaCtl(libmica.MRemove, client); err != nil {
+		return fmt.Errorf("removing mica client %s: %w", client, err)
+	}
+	return nil
+}
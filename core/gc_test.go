@@ -0,0 +1,113 @@
+package core
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestOrphanClients(t *testing.T) {
+	root := t.TempDir()
+
+	// a process that exited stands for the init process of a dead shim
+	dead := exec.Command("true")
+	if err := dead.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, pid := range map[string]int{
+		"alive": os.Getpid(),
+		"dead":  dead.Process.Pid,
+		"nopid": 0,
+	} {
+		bundle := filepath.Join(root, "default", id)
+		if err := os.MkdirAll(bundle, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if pid == 0 {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(bundle, initPidFile), []byte(strconv.Itoa(pid)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the journal of a live task names its client
+	renamed := filepath.Join(root, "k8s.io", "renamed")
+	if err := os.MkdirAll(renamed, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(renamed, initPidFile), []byte(strconv.Itoa(os.Getpid())), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeState(renamed, &taskState{ID: "renamed", Client: "custom"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the journal of a dead task names a client the shim created
+	journaled := filepath.Join(root, "k8s.io", "journaled")
+	if err := os.MkdirAll(journaled, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeState(journaled, &taskState{ID: "journaled", Client: "crashed"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// clients the shim did not create, e.g. with the mica tool, are kept,
+	// and a task of another namespace with the same ID has its own client
+	name := func(owner string) string {
+		ns, id, _ := strings.Cut(owner, "/")
+		return clientName(ns, id)
+	}
+	owners := []string{"default/alive", "default/dead", "default/nopid", "default/unknown", "k8s.io/alive", "k8s.io/renamed"}
+	clients := []string{"custom", "crashed", "manual"}
+	for _, owner := range owners {
+		clients = append(clients, name(owner))
+	}
+	orphans, err := orphanClients(root, clients, owners)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"crashed", name("default/dead"), name("default/nopid"), name("default/unknown"), name("k8s.io/alive")}
+	sort.Strings(want)
+	if !reflect.DeepEqual(orphans, want) {
+		t.Errorf("expected orphans %v, got %v", want, orphans)
+	}
+
+	if _, err := orphanClients(filepath.Join(root, "missing"), []string{"alive"}, owners); err == nil {
+		t.Error("expected an error for a missing bundle root")
+	}
+}
+
+func TestOrphanOwners(t *testing.T) {
+	root := t.TempDir()
+	bundle := filepath.Join(root, "default", "alive")
+	if err := os.MkdirAll(bundle, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(bundle, initPidFile), []byte(strconv.Itoa(os.Getpid())), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "default", "dead"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// an updated task holds references on two images
+	owners := []string{"default/dead", "default/alive", "k8s.io/alive", "default/dead", "default/gone"}
+	orphans, err := orphanOwners(root, owners)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"default/dead", "default/gone", "k8s.io/alive"}; !reflect.DeepEqual(orphans, want) {
+		t.Errorf("expected orphans %v, got %v", want, orphans)
+	}
+
+	if _, err := orphanOwners(filepath.Join(root, "missing"), owners); err == nil {
+		t.Error("expected an error for a missing bundle root")
+	}
+}
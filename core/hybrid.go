@@ -0,0 +1,263 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	defs "mica-shim/definitions"
+	"mica-shim/libmica"
+	log "mica-shim/logger"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/sys/reaper"
+	runc "github.com/containerd/go-runc"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Exit policies of hybrid tasks, set by AnnotationHybridExitPolicy.
+const (
+	// exitPolicyAny ends a hybrid task once the RTOS or the companion exits,
+	// with the exit status of the first one.
+	exitPolicyAny = "any"
+	// exitPolicyAll ends a hybrid task once both exited, with the exit
+	// status of the last one.
+	exitPolicyAll = "all"
+)
+
+const (
+	// companionRuntime is the OCI runtime running companion processes.
+	companionRuntime = "runc"
+	// Name of the file in the bundle that contains the companion pid.
+	companionPidFile = "companion.pid"
+)
+
+// hybridPolicy tells whether a task is a hybrid one and returns its exit
+// policy. The process of a hybrid task is a Linux companion, so its firmware
+// has to be set by annotation.
+func hybridPolicy(spec *specs.Spec) (hybrid bool, policy string, _ error) {
+	if spec.Annotations[defs.AnnotationHybrid] != "true" {
+		return false, "", nil
+	}
+	if spec.Annotations[defs.AnnotationFirmware] == "" {
+		return false, "", fmt.Errorf("hybrid task without %s annotation: %w",
+			defs.AnnotationFirmware, errdefs.ErrInvalidArgument)
+	}
+
+	switch policy = spec.Annotations[defs.AnnotationHybridExitPolicy]; policy {
+	case "":
+		policy = exitPolicyAny
+	case exitPolicyAny, exitPolicyAll:
+	default:
+		return false, "", fmt.Errorf("unknown %s %q: %w",
+			defs.AnnotationHybridExitPolicy, policy, errdefs.ErrInvalidArgument)
+	}
+	return true, policy, nil
+}
+
+// companion is the Linux process of a hybrid task. It is the OCI process of
+// the task's bundle, run by runc with the namespaces and cgroups of the
+// spec, and its output goes to the task's stdout and stderr.
+type companion struct {
+	runtime *runc.Runc
+	id      string
+	pid     int
+
+	done       chan struct{}
+	exitTime   time.Time
+	exitStatus int
+}
+
+// companionExited stops a hybrid task ending with its companion, unless the
+// task is being killed or its init process exited already, when the pid of
+// cmd may belong to another process.
+func (s *micaTaskService) companionExited(id string, comp *companion, cmd *exec.Cmd, doneCtx context.Context) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	proc, ok := s.procs[id]
+	if !ok || !proc.exitTime.IsZero() || doneCtx.Err() != nil || proc.stopping.Load() {
+		return
+	}
+
+	log.Infof("companion of task %s exited with status %d, stopping mica client %s",
+		id, comp.exitStatus, proc.client)
+	// the client stopping is no crash of the RTOS, see watchClient
+	proc.stopping.Store(true)
+	if err := proc.micaCtl(libmica.MStop); err != nil {
+		log.WithError(err).Warnf("failed to stop mica client %s", proc.client)
+	}
+	if err := cmd.Process.Kill(); err != nil {
+		log.WithError(err).Warnf("failed to kill init process %d", proc.pid)
+	}
+}
+
+// newCompanionRuntime returns the runtime running the companions of the
+// namespace ns.
+func newCompanionRuntime(ns string) *runc.Runc {
+	return &runc.Runc{
+		Command:      companionRuntime,
+		Root:         filepath.Join(defs.ShimStateDir, "runc", ns),
+		PdeathSignal: syscall.SIGKILL,
+	}
+}
+
+// createCompanion creates the companion of a hybrid task, which runs once
+// started. Its output goes to stdout and stderr, the stdio of the task it
+// shares with the console.
+func createCompanion(ctx context.Context, id, ns, bundle string, stdout, stderr io.Writer) (_ *companion, retErr error) {
+	r := newCompanionRuntime(ns)
+
+	pio, err := runc.NewPipeIO(0, 0, func(o *runc.IOOption) { o.OpenStdin = false })
+	if err != nil {
+		return nil, fmt.Errorf("creating companion stdio: %w", err)
+	}
+	defer func() {
+		if retErr != nil {
+			pio.Close()
+		}
+	}()
+
+	// subscribe before creating, the companion may exit as soon as started
+	ec := reaper.Default.Subscribe()
+	pidFile := filepath.Join(bundle, companionPidFile)
+	if err := r.Create(ctx, id, bundle, &runc.CreateOpts{PidFile: pidFile, IO: pio}); err != nil {
+		reaper.Default.Unsubscribe(ec)
+		return nil, fmt.Errorf("creating companion: %w", err)
+	}
+	pid, err := runc.ReadPidFile(pidFile)
+	if err != nil {
+		reaper.Default.Unsubscribe(ec)
+		r.Delete(context.Background(), id, &runc.DeleteOpts{Force: true})
+		return nil, fmt.Errorf("reading companion pid: %w", err)
+	}
+
+	var copies sync.WaitGroup
+	copies.Add(2)
+	go copyCompanion(&copies, stdout, pio.Stdout(), "stdout")
+	go copyCompanion(&copies, stderr, pio.Stderr(), "stderr")
+
+	c := &companion{runtime: r, id: id, pid: pid, done: make(chan struct{})}
+	go func() {
+		defer close(c.done)
+		defer pio.Close()
+
+		for e := range ec {
+			if e.Pid == pid {
+				reaper.Default.Unsubscribe(ec)
+				c.exitTime, c.exitStatus = e.Timestamp, e.Status
+				break
+			}
+		}
+		copies.Wait()
+	}()
+
+	return c, nil
+}
+
+// copyCompanion copies an output stream of a companion until it exits.
+func copyCompanion(wg *sync.WaitGroup, w io.Writer, r io.Reader, stream string) {
+	defer wg.Done()
+	if _, err := io.Copy(w, r); err != nil {
+		log.WithError(err).Warnf("failed to copy companion %s", stream)
+	}
+}
+
+// start starts the companion.
+func (c *companion) start(ctx context.Context) error {
+	if err := c.runtime.Start(ctx, c.id); err != nil {
+		return fmt.Errorf("starting companion: %w", err)
+	}
+	return nil
+}
+
+// kill sends sig to the companion, unless it exited already.
+func (c *companion) kill(ctx context.Context, sig syscall.Signal) error {
+	select {
+	case <-c.done:
+		return nil
+	default:
+	}
+	if err := c.runtime.Kill(ctx, c.id, int(sig), nil); err != nil {
+		return fmt.Errorf("sending %s to companion: %w", sig, err)
+	}
+	return nil
+}
+
+// pause freezes the companion, unless it exited already.
+func (c *companion) pause(ctx context.Context) error {
+	select {
+	case <-c.done:
+		return nil
+	default:
+	}
+	if err := c.runtime.Pause(ctx, c.id); err != nil {
+		return fmt.Errorf("pausing companion: %w", err)
+	}
+	return nil
+}
+
+// update applies resources to the cgroup of the companion, unless it exited
+// already.
+func (c *companion) update(ctx context.Context, resources *specs.LinuxResources) error {
+	select {
+	case <-c.done:
+		return nil
+	default:
+	}
+	if err := c.runtime.Update(ctx, c.id, resources); err != nil {
+		return fmt.Errorf("updating companion: %w", err)
+	}
+	return nil
+}
+
+// resume thaws the companion, unless it exited already.
+func (c *companion) resume(ctx context.Context) error {
+	select {
+	case <-c.done:
+		return nil
+	default:
+	}
+	if err := c.runtime.Resume(ctx, c.id); err != nil {
+		return fmt.Errorf("resuming companion: %w", err)
+	}
+	return nil
+}
+
+// delete removes the companion, killing it if still running.
+func (c *companion) delete(ctx context.Context) error {
+	if err := c.runtime.Delete(ctx, c.id, &runc.DeleteOpts{Force: true}); err != nil {
+		return fmt.Errorf("deleting companion: %w", err)
+	}
+	return nil
+}
+
+// join is called once the RTOS of a hybrid task is done. It waits for the
+// companion, which is killed first under exitPolicyAny, and returns the exit
+// status of the task given the one of the RTOS.
+func (c *companion) join(policy string, rtosStatus int) int {
+	select {
+	case <-c.done:
+		// the companion exited first
+		if policy == exitPolicyAny {
+			return c.exitStatus
+		}
+		return rtosStatus
+	default:
+	}
+
+	if policy == exitPolicyAny {
+		if err := c.kill(context.Background(), syscall.SIGKILL); err != nil {
+			log.WithError(err).Warnf("failed to kill companion of task %s", c.id)
+		}
+	}
+	<-c.done
+	if policy == exitPolicyAny {
+		return rtosStatus
+	}
+	return c.exitStatus
+}
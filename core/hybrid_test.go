@@ -0,0 +1,86 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	defs "mica-shim/definitions"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestHybridPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		annotations map[string]string
+		hybrid      bool
+		policy      string
+		wantErr     bool
+	}{
+		{name: "rtos only"},
+		{
+			name:        "default policy",
+			annotations: map[string]string{defs.AnnotationHybrid: "true", defs.AnnotationFirmware: "/lib/firmware/zephyr.elf"},
+			hybrid:      true,
+			policy:      exitPolicyAny,
+		},
+		{
+			name: "all",
+			annotations: map[string]string{defs.AnnotationHybrid: "true", defs.AnnotationFirmware: "/lib/firmware/zephyr.elf",
+				defs.AnnotationHybridExitPolicy: "all"},
+			hybrid: true,
+			policy: exitPolicyAll,
+		},
+		{
+			name:        "no firmware annotation",
+			annotations: map[string]string{defs.AnnotationHybrid: "true"},
+			wantErr:     true,
+		},
+		{
+			name: "unknown policy",
+			annotations: map[string]string{defs.AnnotationHybrid: "true", defs.AnnotationFirmware: "/lib/firmware/zephyr.elf",
+				defs.AnnotationHybridExitPolicy: "first"},
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hybrid, policy, err := hybridPolicy(&specs.Spec{Annotations: tc.annotations})
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil || hybrid != tc.hybrid || policy != tc.policy {
+				t.Errorf("expected %v %q, got %v %q: %v", tc.hybrid, tc.policy, hybrid, policy, err)
+			}
+		})
+	}
+}
+
+func TestCompanionJoin(t *testing.T) {
+	exited := func(status int) *companion {
+		c := &companion{done: make(chan struct{}), exitStatus: status}
+		close(c.done)
+		return c
+	}
+
+	// the companion exited first
+	if status := exited(3).join(exitPolicyAny, 137); status != 3 {
+		t.Errorf("expected the companion status under %s, got %d", exitPolicyAny, status)
+	}
+	if status := exited(3).join(exitPolicyAll, 137); status != 137 {
+		t.Errorf("expected the RTOS status under %s, got %d", exitPolicyAll, status)
+	}
+
+	// the RTOS exited first and the companion follows later
+	c := &companion{done: make(chan struct{})}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		c.exitStatus = 1
+		close(c.done)
+	}()
+	if status := c.join(exitPolicyAll, 0); status != 1 {
+		t.Errorf("expected the companion status under %s, got %d", exitPolicyAll, status)
+	}
+}
@@ -2,6 +2,7 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -11,8 +12,13 @@ import (
 	"syscall"
 	"time"
 
+	defs "mica-shim/definitions"
+	"mica-shim/firmware"
+	"mica-shim/libmica"
+
 	"github.com/containerd/containerd/log"
 	"github.com/containerd/containerd/runtime/v2/shim"
+	runc "github.com/containerd/go-runc"
 )
 
 // containerd-specific environment variables set while invoking the shim's
@@ -26,6 +32,9 @@ const (
 // Name of the file that contains the init pid.
 const initPidFile = "init.pid"
 
+// Name of the file that contains the address of the shim socket.
+const addressFile = "address"
+
 // https://pubs.opengroup.org/onlinepubs/9699919799/utilities/V3_chap02.html#tag_18_21_18
 const exitCodeSignal = 128
 
@@ -108,7 +117,7 @@ func (*manager) Start(ctx context.Context, containerID string, opts shim.StartOp
 			return "", fmt.Errorf("creating new shim socket: %w", err)
 
 		case shim.CanConnect(sockAddr):
-			if err := shim.WriteAddress("address", sockAddr); err != nil {
+			if err := shim.WriteAddress(addressFile, sockAddr); err != nil {
 				return "", fmt.Errorf("writing socket address file: %w", err)
 			}
 			return sockAddr, nil
@@ -134,7 +143,7 @@ func (*manager) Start(ctx context.Context, containerID string, opts shim.StartOp
 		}
 	}()
 
-	if err := shim.WriteAddress("address", sockAddr); err != nil {
+	if err := shim.WriteAddress(addressFile, sockAddr); err != nil {
 		return "", fmt.Errorf("writing socket address file: %w", err)
 	}
 
@@ -194,8 +203,8 @@ func (*manager) Stop(ctx context.Context, containerID string) (shim.StopStatus,
 		return shim.StopStatus{}, fmt.Errorf("getting current working directory: %w", err)
 	}
 
-	pidPath := filepath.Join(filepath.Join(filepath.Dir(cwd), containerID), initPidFile)
-	pid, err := readPidFile(pidPath)
+	bundle := filepath.Join(filepath.Dir(cwd), containerID)
+	pid, err := readPidFile(filepath.Join(bundle, initPidFile))
 	if err != nil {
 		log.G(ctx).WithError(err).Warn("failed to read init pid file")
 	}
@@ -211,11 +220,62 @@ func (*manager) Stop(ctx context.Context, containerID string) (shim.StopStatus,
 		}
 	}
 
-	return shim.StopStatus{
+	status := shim.StopStatus{
 		Pid:        pid,
 		ExitedAt:   time.Now(),
 		ExitStatus: int(exitCodeSignal + syscall.SIGKILL),
-	}, nil
+	}
+
+	// the journal of the task tells what the dead shim left behind
+	st, err := readState(bundle)
+	switch {
+	case err == nil:
+		cleanupTask(ctx, st)
+		if st.Status == statusStopped {
+			status.ExitedAt, status.ExitStatus = st.ExitedAt, st.ExitStatus
+		}
+		if err := removeState(bundle); err != nil {
+			log.G(ctx).WithError(err).Warn("failed to remove task state")
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		log.G(ctx).WithError(err).Warn("failed to read task state")
+	}
+
+	return status, nil
+}
+
+// cleanupTask releases what the task journaled as st holds: its client, its
+// firmware, its companion and its rootfs mount.
+func cleanupTask(ctx context.Context, st *taskState) {
+	if libmica.MicadRunning() && libmica.ClientExists(st.Client) {
+		if _, err := libmica.MicaCtl(libmica.MStop, st.Client); err != nil {
+			log.G(ctx).WithError(err).Warnf("failed to stop mica client %s", st.Client)
+		}
+		if _, err := libmica.MicaCtl(libmica.MRemove, st.Client); err != nil {
+			log.G(ctx).WithError(err).Warnf("failed to remove mica client %s", st.Client)
+		}
+	}
+
+	if st.Hybrid {
+		r := newCompanionRuntime(st.Namespace)
+		if err := r.Delete(ctx, st.ID, &runc.DeleteOpts{Force: true}); err != nil {
+			log.G(ctx).WithError(err).Warnf("failed to delete companion of task %s", st.ID)
+		}
+	}
+
+	if st.RootfsMounted {
+		if err := unmountRootfs(st.Rootfs); err != nil {
+			log.G(ctx).WithError(err).Warn("failed to unmount rootfs")
+		}
+	}
+
+	store, err := firmware.NewStore(defs.FirmwareStoreDir)
+	if err == nil {
+		err = store.Release(st.Firmware, st.Namespace+"/"+st.ID)
+	}
+	if err != nil {
+		log.G(ctx).WithError(err).Warnf("failed to release firmware %s", st.Firmware)
+	}
 }
 
 // readPidFile reads the pid file at the provided path and returns the pid it
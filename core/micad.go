@@ -0,0 +1,138 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+
+	"mica-shim/libmica"
+	log "mica-shim/logger"
+)
+
+// watchMicad follows the micad instance running the clients of the tasks, and
+// reconciles the tasks with micad once it restarted: when its create socket
+// was created anew, or when it answers again after being unreachable.
+func (s *micaTaskService) watchMicad() {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	var announced uint64
+	for range ticker.C {
+		s.checkMicad(&announced)
+	}
+}
+
+// checkMicad checks the micad instance once for watchMicad. announced is the
+// instance whose restart was last logged.
+func (s *micaTaskService) checkMicad(announced *uint64) {
+	id, err := libmica.MicadInstance()
+	instance := s.micadInstance.Load()
+	switch {
+	case err != nil:
+		if instance != 0 {
+			log.WithError(err).Warn("micad went away, waiting for it to come back")
+			s.micadInstance.Store(0)
+		}
+		return
+	case id == instance && !s.micadUnreachable.Load():
+		return
+	case id != instance && id != *announced:
+		if instance == 0 {
+			log.Info("micad is back, reconciling tasks")
+		} else {
+			log.Info("micad restarted, reconciling tasks")
+		}
+		*announced = id
+	}
+
+	if s.reconcileClients() {
+		s.micadInstance.Store(id)
+		s.micadUnreachable.Store(false)
+	}
+}
+
+// reconcileClients checks the client of every task against micad, and
+// re-adopts, recreates or gives up on it. It tells whether micad could be
+// reached.
+func (s *micaTaskService) reconcileClients() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for id, proc := range s.procs {
+		if !proc.exitTime.IsZero() || proc.stopping.Load() || proc.updating {
+			continue
+		}
+		if !s.reconcileClient(id, proc) {
+			return false
+		}
+	}
+	return true
+}
+
+// reconcileClient brings the client of a task back in line with the task
+// after micad restarted. A client micad lost is created again, and started
+// again if the task runs; the task exits if that fails. It tells whether
+// micad could be reached. s.m must be held.
+func (s *micaTaskService) reconcileClient(id string, proc *initProcess) bool {
+	exists := libmica.ClientExists(proc.client)
+	running := !proc.startedAt.IsZero() && !proc.paused
+
+	if exists {
+		if !running {
+			log.Infof("re-adopting mica client %s of task %s", proc.client, id)
+			return true
+		}
+		st, err := proc.micaStatus()
+		switch {
+		case libmica.Unreachable(err):
+			return false
+		case err != nil:
+			log.WithError(err).Warnf("failed to get status of mica client %s, re-adopting it", proc.client)
+			return true
+		case strings.EqualFold(st.State, clientRunning):
+			log.Infof("re-adopting running mica client %s of task %s", proc.client, id)
+			return true
+		}
+		log.Infof("mica client %s of task %s is %s, starting it again", proc.client, id, st.State)
+	} else {
+		log.Infof("micad lost mica client %s of task %s, creating it again on cpu %d", proc.client, id, proc.cpu)
+		if err := proc.micaCreate(proc.cpu); err != nil {
+			if libmica.Unreachable(err) {
+				return false
+			}
+			s.clientLost(id, proc, exitStatusRemoved, fmt.Errorf("creating mica client again: %w", err))
+			return true
+		}
+		if proc.paused {
+			// the created client is stopped, and started on resume
+			proc.pausedByStop = true
+			proc.journal()
+		}
+	}
+
+	if !running {
+		return true
+	}
+	if err := proc.micaCtl(libmica.MStart); err != nil {
+		if libmica.Unreachable(err) {
+			return false
+		}
+		s.clientLost(id, proc, exitStatusStopped, fmt.Errorf("starting mica client again: %w", err))
+		return true
+	}
+	proc.restarts++
+	s.restarted(id, proc)
+	return true
+}
+
+// clientLost stops a task whose client could not be brought back after micad
+// restarted. s.m must be held.
+func (s *micaTaskService) clientLost(id string, proc *initProcess, status int, err error) {
+	log.WithError(err).Warnf("lost mica client %s, stopping task %s", proc.client, id)
+	proc.lastFailure = fmt.Sprintf("mica client %s was lost with micad", proc.client)
+	proc.lostStatus = status
+	if err := syscall.Kill(proc.pid, syscall.SIGKILL); err != nil {
+		log.WithError(err).Errorf("failed to kill init process %d", proc.pid)
+	}
+}
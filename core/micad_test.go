@@ -0,0 +1,443 @@
+package core
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	goio "io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	defs "mica-shim/definitions"
+	"mica-shim/firmware"
+	"mica-shim/io"
+	"mica-shim/libmica"
+)
+
+// fakeMicad serves the micad sockets in a temporary directory and keeps the
+// state of its clients as micad does.
+type fakeMicad struct {
+	t   *testing.T
+	dir string
+
+	mu      sync.Mutex
+	create  net.Listener
+	clients map[string]*fakeClient
+	// boots tells whether the firmware at path runs once started, all do if
+	// nil; the others crash.
+	boots func(path string) bool
+	// fails tells whether micad fails to start the firmware at path, as when
+	// the remote processor rejects it; none does if nil.
+	fails func(path string) bool
+	// unknown are the commands micad fails, as older ones do MPause.
+	unknown map[libmica.MicaCommand]bool
+	// onStatus is called before answering a status request.
+	onStatus func(client string)
+}
+
+// fakeClient is a client of a fakeMicad.
+type fakeClient struct {
+	cpu   uint32
+	path  string
+	state string
+	l     net.Listener
+}
+
+// newFakeMicad starts a fake micad, which the libmica calls of the test
+// talk to.
+func newFakeMicad(t *testing.T) *fakeMicad {
+	t.Helper()
+	// socket paths are short, unlike the temporary directories of tests
+	dir, err := os.MkdirTemp("", "micad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := libmica.SocketDir
+	libmica.SocketDir = dir
+
+	m := &fakeMicad{t: t, dir: dir, clients: make(map[string]*fakeClient), unknown: make(map[libmica.MicaCommand]bool)}
+	t.Cleanup(func() {
+		m.stop()
+		libmica.SocketDir = saved
+		os.RemoveAll(dir)
+	})
+	m.start()
+	return m
+}
+
+// start starts micad, with a create socket of its own.
+func (m *fakeMicad) start() {
+	m.t.Helper()
+	l, err := net.Listen("unix", filepath.Join(m.dir, defs.MicaSocketName))
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	m.mu.Lock()
+	m.create = l
+	m.mu.Unlock()
+	go m.serve(l, m.handleCreate)
+}
+
+// restart restarts micad, which loses its clients. The new create socket is
+// bound before the old one goes, so that it cannot get its inode.
+func (m *fakeMicad) restart() {
+	m.t.Helper()
+	path := filepath.Join(m.dir, defs.MicaSocketName)
+	l, err := net.Listen("unix", path+".new")
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Rename(path+".new", path); err != nil {
+		m.t.Fatal(err)
+	}
+
+	m.mu.Lock()
+	old := m.create
+	m.create = nil
+	m.mu.Unlock()
+	old.(*net.UnixListener).SetUnlinkOnClose(false)
+	m.stop()
+	old.Close()
+
+	m.mu.Lock()
+	m.create = l
+	m.mu.Unlock()
+	go m.serve(l, m.handleCreate)
+}
+
+// handleCreate handles a create request.
+func (m *fakeMicad) handleCreate(conn net.Conn) {
+	msg := make([]byte, 325)
+	if _, err := goio.ReadFull(conn, msg); err != nil {
+		return
+	}
+	cpu := binary.LittleEndian.Uint32(msg[0:4])
+	name := strings.TrimRight(string(msg[4:36]), "\x00")
+	path := strings.TrimRight(string(msg[36:164]), "\x00")
+	m.reply(conn, "", m.createClient(name, path, cpu))
+}
+
+// stop stops micad, which loses its clients.
+func (m *fakeMicad) stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.create != nil {
+		m.create.Close()
+		m.create = nil
+	}
+	for name, c := range m.clients {
+		c.l.Close()
+		delete(m.clients, name)
+	}
+}
+
+// serve handles the connections to l until it is closed.
+func (m *fakeMicad) serve(l net.Listener, handle func(net.Conn)) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			handle(conn)
+		}()
+	}
+}
+
+// reply answers a request with output, and whether it succeeded.
+func (m *fakeMicad) reply(conn net.Conn, output string, ok bool) {
+	result := defs.MicaSuccess
+	if !ok {
+		result = defs.MicaFailed
+	}
+	fmt.Fprintf(conn, "%s\n%s", output, result)
+}
+
+// createClient creates the client name, which boots path on cpu once
+// started.
+func (m *fakeMicad) createClient(name, path string, cpu uint32) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.clients[name]; ok {
+		return false
+	}
+	l, err := net.Listen("unix", filepath.Join(m.dir, name+".socket"))
+	if err != nil {
+		return false
+	}
+	m.clients[name] = &fakeClient{cpu: cpu, path: path, state: "Offline", l: l}
+	go m.serve(l, func(conn net.Conn) {
+		b := make([]byte, 64)
+		n, err := conn.Read(b)
+		if err != nil {
+			return
+		}
+		output, ok := m.control(name, libmica.MicaCommand(b[:n]))
+		m.reply(conn, output, ok)
+	})
+	return true
+}
+
+// control runs cmd on the client name.
+func (m *fakeMicad) control(name string, cmd libmica.MicaCommand) (string, bool) {
+	if cmd == libmica.MStatus && m.onStatus != nil {
+		m.onStatus(name)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.clients[name]
+	if !ok || m.unknown[cmd] {
+		return "", false
+	}
+	switch cmd {
+	case libmica.MStart, libmica.MResume:
+		if m.fails != nil && m.fails(c.path) {
+			return "", false
+		}
+		c.state = "Running"
+		if m.boots != nil && !m.boots(c.path) {
+			c.state = "Crashed"
+		}
+	case libmica.MStop:
+		c.state = "Offline"
+	case libmica.MPause:
+		c.state = "Paused"
+	case libmica.MRemove:
+		c.l.Close()
+		delete(m.clients, name)
+	case libmica.MStatus:
+		return fmt.Sprintf("Name Assigned-CPU State Service\n%s %d %s", name, c.cpu, c.state), true
+	default:
+		return "", false
+	}
+	return "", true
+}
+
+// client returns the client name, nil if there is none.
+func (m *fakeMicad) client(name string) *fakeClient {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c, ok := m.clients[name]; ok {
+		cc := *c
+		return &cc
+	}
+	return nil
+}
+
+// setState sets the state of the client name, e.g. as its RTOS crashed.
+func (m *fakeMicad) setState(name, state string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clients[name].state = state
+}
+
+// removeClient removes the client name, as micad does on its own.
+func (m *fakeMicad) removeClient(name string) {
+	m.control(name, libmica.MRemove)
+}
+
+// newTestTask returns a service running a task with the firmware at fwPath,
+// if any, whose client micad created and started. The init process of the
+// task is a sleep process, killed once the test is done.
+func newTestTask(t *testing.T, m *fakeMicad, fwPath string) (*micaTaskService, *initProcess) {
+	t.Helper()
+	init := exec.Command("sleep", "60")
+	if err := init.Start(); err != nil {
+		t.Fatal(err)
+	}
+	doneCtx, markDone := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		markDone()
+		init.Process.Kill()
+		init.Wait()
+	})
+
+	store, err := firmware.NewStore(filepath.Join(t.TempDir(), "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &micaTaskService{procs: make(initProcByTaskID), store: store}
+	id, err := libmica.MicadInstance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.micadInstance.Store(id)
+
+	image, meta := firmware.Image{}, &firmware.Metadata{}
+	if fwPath != "" {
+		if image, err = store.Add(fwPath, "default/task"); err != nil {
+			t.Fatal(err)
+		}
+		if meta, err = firmware.Validate(image.Path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	proc := &initProcess{
+		id:        "task",
+		pid:       init.Process.Pid,
+		doneCtx:   doneCtx,
+		namespace: "default",
+		bundle:    t.TempDir(),
+		rootfs:    t.TempDir(),
+		client:    clientName("default", "task"),
+		cpu:       3,
+		image:     image,
+		meta:      meta,
+		console:   io.NewConsole(io.ConsoleConfig{}),
+		// no device shows up, so that restarts do not query micad
+		consoleDevice: filepath.Join(t.TempDir(), "ttyRPMSG0"),
+		options:       defaultOptions(),
+		execs:         make(map[string]*execProcess),
+	}
+	s.procs[proc.id] = proc
+
+	if err := proc.micaCreate(proc.cpu); err != nil {
+		t.Fatal(err)
+	}
+	if err := proc.micaCtl(libmica.MStart); err != nil {
+		t.Fatal(err)
+	}
+	proc.startedAt = time.Now()
+	return s, proc
+}
+
+// killed tells whether the process pid was killed, and so is a zombie until
+// reaped.
+func killed(t *testing.T, pid int) bool {
+	t.Helper()
+	for i := 0; i < 50; i++ {
+		stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+		if err != nil {
+			return true
+		}
+		if _, state, _ := strings.Cut(string(stat), ") "); strings.HasPrefix(state, "Z") {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestCheckMicad(t *testing.T) {
+	m := newFakeMicad(t)
+	s, proc := newTestTask(t, m, "")
+	var announced uint64
+
+	m.stop()
+	s.checkMicad(&announced)
+	if s.micadInstance.Load() != 0 {
+		t.Fatalf("expected micad to be gone")
+	}
+	m.start()
+	s.checkMicad(&announced)
+	if s.micadInstance.Load() == 0 || proc.restarts != 1 {
+		t.Fatalf("expected the tasks to be reconciled once micad is back, got %d restarts", proc.restarts)
+	}
+	if c := m.client(proc.client); c == nil || c.state != clientRunning {
+		t.Errorf("expected the client to be created and started again, got %+v", c)
+	}
+
+	instance := s.micadInstance.Load()
+	m.restart()
+	s.checkMicad(&announced)
+	if s.micadInstance.Load() == instance || announced != s.micadInstance.Load() || proc.restarts != 2 {
+		t.Fatalf("expected the tasks to be reconciled once micad restarted, got %d restarts", proc.restarts)
+	}
+
+	// a micad that did not answer is reconciled with once it does again
+	s.micadUnreachable.Store(true)
+	m.setState(proc.client, "Crashed")
+	s.checkMicad(&announced)
+	if s.micadUnreachable.Load() || proc.restarts != 3 {
+		t.Errorf("expected the tasks to be reconciled once micad answers, got %d restarts", proc.restarts)
+	}
+}
+
+func TestReconcileClients(t *testing.T) {
+	tests := []struct {
+		name string
+		// prepare sets the task and micad up before they are reconciled
+		prepare  func(m *fakeMicad, proc *initProcess)
+		restarts int
+		state    string
+		status   int
+	}{
+		{
+			name:    "running",
+			prepare: func(*fakeMicad, *initProcess) {},
+			state:   clientRunning,
+		},
+		{
+			name:     "lost",
+			prepare:  func(m *fakeMicad, _ *initProcess) { m.restart() },
+			restarts: 1,
+			state:    clientRunning,
+		},
+		{
+			name:     "stopped",
+			prepare:  func(m *fakeMicad, proc *initProcess) { m.setState(proc.client, "Offline") },
+			restarts: 1,
+			state:    clientRunning,
+		},
+		{
+			name: "paused",
+			prepare: func(m *fakeMicad, proc *initProcess) {
+				proc.paused = true
+				m.restart()
+			},
+			state: "Offline",
+		},
+		{
+			name: "not starting",
+			prepare: func(m *fakeMicad, _ *initProcess) {
+				m.restart()
+				m.fails = func(string) bool { return true }
+			},
+			state:  "Offline",
+			status: exitStatusStopped,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newFakeMicad(t)
+			s, proc := newTestTask(t, m, "")
+			tt.prepare(m, proc)
+
+			if !s.reconcileClients() {
+				t.Fatalf("expected micad to be reachable")
+			}
+			if c := m.client(proc.client); c == nil || c.state != tt.state {
+				t.Errorf("expected a %s client, got %+v", tt.state, c)
+			}
+			if proc.restarts != tt.restarts || proc.lostStatus != tt.status {
+				t.Errorf("expected %d restarts and lost status %d, got %d and %d",
+					tt.restarts, tt.status, proc.restarts, proc.lostStatus)
+			}
+			if proc.paused != proc.pausedByStop {
+				t.Errorf("expected the client of a paused task to be started on resume")
+			}
+			if k := killed(t, proc.pid); k != (tt.status != 0) {
+				t.Errorf("expected init killed %v, got %v", tt.status != 0, k)
+			}
+		})
+	}
+
+	// micad down is no reason to give up on the clients
+	m := newFakeMicad(t)
+	s, proc := newTestTask(t, m, "")
+	m.stop()
+	if s.reconcileClients() || proc.lostStatus != 0 {
+		t.Errorf("expected micad to be unreachable and the task to be kept")
+	}
+}
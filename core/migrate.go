@@ -0,0 +1,142 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"mica-shim/libmica"
+	log "mica-shim/logger"
+
+	"github.com/containerd/containerd/errdefs"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// migrateTask moves the mica client of a task to cpu. The client is stopped
+// and removed, which releases its core, then created again on cpu with the
+// same firmware and restarted if it was running; its firmware boots again.
+// On failure the client is brought back on its old core.
+func (s *micaTaskService) migrateTask(id string, cpu uint32) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	proc, ok := s.procs[id]
+	if !ok {
+		return fmt.Errorf("task not created: %w", errdefs.ErrNotFound)
+	}
+	if !proc.exitTime.IsZero() {
+		return fmt.Errorf("task %s is not running: %w", id, errdefs.ErrFailedPrecondition)
+	}
+	if proc.paused {
+		return fmt.Errorf("task %s is paused: %w", id, errdefs.ErrFailedPrecondition)
+	}
+	if proc.updating {
+		return fmt.Errorf("firmware of task %s is being updated: %w", id, errdefs.ErrFailedPrecondition)
+	}
+	if cpu == proc.cpu {
+		return nil
+	}
+
+	running := !proc.startedAt.IsZero()
+	log.Infof("moving mica client %s from cpu %d to cpu %d", proc.client, proc.cpu, cpu)
+
+	if err := s.removeClient(id, proc, running); err != nil {
+		return err
+	}
+	if err := s.recreateClient(id, proc, cpu, running); err != nil {
+		err = fmt.Errorf("moving mica client %s to cpu %d: %w", proc.client, cpu, err)
+		if rerr := s.recreateClient(id, proc, proc.cpu, running); rerr != nil {
+			log.WithError(rerr).Errorf("failed to bring mica client %s back on cpu %d", proc.client, proc.cpu)
+			return errors.Join(err, rerr)
+		}
+		return err
+	}
+	proc.cpu = cpu
+	proc.journal()
+	return nil
+}
+
+// updateResources applies new resources to a task. They go to the cgroup of
+// the companion of a hybrid task, and a cpuset without the core of the RTOS
+// moves it to its first CPU. CRI sends the whole cpuset on every update, so
+// the RTOS stays where it is as long as its core is part of it.
+func (s *micaTaskService) updateResources(ctx context.Context, id string, resources *specs.LinuxResources) error {
+	s.m.RLock()
+	proc, ok := s.procs[id]
+	var comp *companion
+	var cpu uint32
+	if ok {
+		comp, cpu = proc.companion, proc.cpu
+	}
+	s.m.RUnlock()
+	if !ok {
+		return fmt.Errorf("task not created: %w", errdefs.ErrNotFound)
+	}
+
+	if comp != nil {
+		if err := comp.update(ctx, resources); err != nil {
+			return err
+		}
+	}
+
+	if resources.CPU == nil || resources.CPU.Cpus == "" {
+		return nil
+	}
+	if in, err := cpusetContains(resources.CPU.Cpus, cpu); err != nil || in {
+		return err
+	}
+	first, err := firstCPU(resources.CPU.Cpus)
+	if err != nil {
+		return err
+	}
+	return s.migrateTask(id, first)
+}
+
+// removeClient stops the mica client of a task if running and removes it,
+// which releases its core. The client is restarted if it cannot be removed.
+// s.m must be held.
+func (s *micaTaskService) removeClient(id string, proc *initProcess, running bool) error {
+	if running {
+		if err := proc.micaCtl(libmica.MStop); err != nil {
+			return fmt.Errorf("stopping mica client %s: %w", proc.client, err)
+		}
+	}
+	if err := proc.micaCtl(libmica.MRemove); err != nil {
+		err = fmt.Errorf("removing mica client %s: %w", proc.client, err)
+		if running {
+			if serr := proc.micaCtl(libmica.MStart); serr != nil {
+				return errors.Join(err, fmt.Errorf("restarting mica client %s: %w", proc.client, serr))
+			}
+			s.restarted(id, proc)
+		}
+		return err
+	}
+	return nil
+}
+
+// recreateClient creates the removed mica client of a task on cpu, and starts
+// it if it was running. s.m must be held.
+func (s *micaTaskService) recreateClient(id string, proc *initProcess, cpu uint32, running bool) error {
+	if err := proc.micaCreate(cpu); err != nil {
+		return fmt.Errorf("creating mica client on cpu %d: %w", cpu, err)
+	}
+	if !running {
+		return nil
+	}
+	if err := proc.micaCtl(libmica.MStart); err != nil {
+		if rerr := proc.micaCtl(libmica.MRemove); rerr != nil {
+			log.WithError(rerr).Warnf("failed to remove mica client %s", proc.client)
+		}
+		return fmt.Errorf("starting mica client on cpu %d: %w", cpu, err)
+	}
+	s.restarted(id, proc)
+	return nil
+}
+
+// restarted records that the firmware of a task booted again and attaches
+// its new console. s.m must be held.
+func (s *micaTaskService) restarted(id string, proc *initProcess) {
+	proc.startedAt = time.Now()
+	proc.journal()
+	go s.reattachConsole(id, proc)
+}
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	defs "mica-shim/definitions"
+
+	"github.com/containerd/containerd/errdefs"
+	runtimeoptions "github.com/containerd/containerd/pkg/runtimeoptions/v1"
+	"github.com/containerd/typeurl/v2"
+	"github.com/pelletier/go-toml"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Options are the runtime options of the shim. containerd passes them as
+// runtimeoptions.Options, whose TOML config file or body holds these keys,
+// e.g. from the options section of the runtime in the CRI plugin config:
+//
+//	[plugins."io.containerd.grpc.v1.cri".containerd.runtimes.mica.options]
+//	  ConsoleLogMaxSize = 1048576
+//	  ConsoleLogMaxFiles = 3
+type Options struct {
+	// ConsoleLogInBundle writes the console log of a task into its bundle
+	// rather than to <ConsoleLogDir>/<namespace>/<id>.log.
+	ConsoleLogInBundle bool `toml:"ConsoleLogInBundle"`
+	// ConsoleLogMaxSize is the size in bytes a console log is rotated at.
+	ConsoleLogMaxSize int64 `toml:"ConsoleLogMaxSize"`
+	// ConsoleLogMaxFiles is the number of console log files kept per task,
+	// the current one included.
+	ConsoleLogMaxFiles int `toml:"ConsoleLogMaxFiles"`
+}
+
+// Name of the console log file of a task in its bundle.
+const consoleLogFile = "console.log"
+
+// defaultOptions returns the options used for anything not set.
+func defaultOptions() *Options {
+	return &Options{
+		ConsoleLogMaxSize:  10 << 20,
+		ConsoleLogMaxFiles: 5,
+	}
+}
+
+// parseOptions decodes the runtime options sent along with a task.
+func parseOptions(options *anypb.Any) (*Options, error) {
+	opts := defaultOptions()
+	if options == nil {
+		return opts, nil
+	}
+
+	v, err := typeurl.UnmarshalAny(options)
+	if err != nil {
+		return nil, fmt.Errorf("decoding runtime options: %w", err)
+	}
+	ro, ok := v.(*runtimeoptions.Options)
+	if !ok {
+		return nil, fmt.Errorf("unsupported runtime options %s: %w", options.GetTypeUrl(), errdefs.ErrInvalidArgument)
+	}
+
+	body := ro.ConfigBody
+	if ro.ConfigPath != "" {
+		if body, err = os.ReadFile(ro.ConfigPath); err != nil {
+			return nil, fmt.Errorf("reading runtime options: %w", err)
+		}
+	}
+	if len(body) > 0 {
+		if err := toml.Unmarshal(body, opts); err != nil {
+			return nil, fmt.Errorf("decoding runtime options: %w", err)
+		}
+	}
+
+	if opts.ConsoleLogMaxSize <= 0 || opts.ConsoleLogMaxFiles <= 0 {
+		return nil, fmt.Errorf("console log limits must be positive: %w", errdefs.ErrInvalidArgument)
+	}
+	return opts, nil
+}
+
+// consoleLogPath returns where the console log of a task goes.
+func (o *Options) consoleLogPath(bundle, ns, id string) string {
+	if o.ConsoleLogInBundle {
+		return filepath.Join(bundle, consoleLogFile)
+	}
+	return filepath.Join(defs.ConsoleLogDir, ns, id+".log")
+}
@@ -0,0 +1,47 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	runtimeoptions "github.com/containerd/containerd/pkg/runtimeoptions/v1"
+	"github.com/containerd/containerd/protobuf"
+)
+
+func TestParseOptions(t *testing.T) {
+	opts, err := parseOptions(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *opts != *defaultOptions() {
+		t.Errorf("expected default options, got %+v", opts)
+	}
+
+	body := []byte("ConsoleLogInBundle = true\nConsoleLogMaxSize = 4096\n")
+	any, err := protobuf.MarshalAnyToProto(&runtimeoptions.Options{ConfigBody: body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts, err = parseOptions(any); err != nil {
+		t.Fatal(err)
+	}
+	if !opts.ConsoleLogInBundle || opts.ConsoleLogMaxSize != 4096 || opts.ConsoleLogMaxFiles != 5 {
+		t.Errorf("unexpected options %+v", opts)
+	}
+	if p := opts.consoleLogPath("/run/bundle", "default", "zephyr"); p != "/run/bundle/console.log" {
+		t.Errorf("unexpected console log path %s", p)
+	}
+
+	path := filepath.Join(t.TempDir(), "mica.toml")
+	if err := os.WriteFile(path, []byte("ConsoleLogMaxFiles = 0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	any, err = protobuf.MarshalAnyToProto(&runtimeoptions.Options{ConfigPath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseOptions(any); err == nil {
+		t.Errorf("expected invalid console log limits to be rejected")
+	}
+}
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"context"
+	"fmt"
+
+	"mica-shim/libmica"
+	log "mica-shim/logger"
+
+	"github.com/containerd/containerd/errdefs"
+)
+
+// pauseTask halts the RTOS core of a task and freezes its companion, keeping
+// the client, its firmware and its CPU reserved. It returns the namespace of
+// the task.
+//
+// micad halts the core when it supports pausing; otherwise the remote
+// processor is stopped, and the firmware restarts from its entry point on
+// resume.
+func (s *micaTaskService) pauseTask(ctx context.Context, id string) (string, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	proc, ok := s.procs[id]
+	if !ok {
+		return "", fmt.Errorf("task not created: %w", errdefs.ErrNotFound)
+	}
+	if !proc.exitTime.IsZero() {
+		return "", errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "task %s is not running", id)
+	}
+	if proc.paused {
+		return "", errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "task %s is already paused", id)
+	}
+	if proc.updating {
+		return "", errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "firmware of task %s is being updated", id)
+	}
+
+	if proc.companion != nil {
+		if err := proc.companion.pause(ctx); err != nil {
+			return "", err
+		}
+	}
+
+	// older micad do not know MPause, which is no micad error to count
+	byStop := false
+	if _, err := libmica.MicaCtl(libmica.MPause, proc.client); err != nil {
+		log.WithError(err).Infof("micad cannot pause mica client %s, stopping it", proc.client)
+		if err := proc.micaCtl(libmica.MStop); err != nil {
+			if proc.companion != nil {
+				if rerr := proc.companion.resume(ctx); rerr != nil {
+					log.WithError(rerr).Warnf("failed to resume companion of task %s", id)
+				}
+			}
+			return "", fmt.Errorf("pausing mica client %s: %w", proc.client, err)
+		}
+		byStop = true
+	}
+
+	proc.paused, proc.pausedByStop = true, byStop
+	proc.journal()
+	return proc.namespace, nil
+}
+
+// resumeTask continues the RTOS core and thaws the companion of a paused
+// task. It returns the namespace of the task.
+func (s *micaTaskService) resumeTask(ctx context.Context, id string) (string, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	proc, ok := s.procs[id]
+	if !ok {
+		return "", fmt.Errorf("task not created: %w", errdefs.ErrNotFound)
+	}
+	if !proc.paused || !proc.exitTime.IsZero() {
+		return "", errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "task %s is not paused", id)
+	}
+
+	cmd := libmica.MResume
+	if proc.pausedByStop {
+		cmd = libmica.MStart
+	}
+	if err := proc.micaCtl(cmd); err != nil {
+		return "", fmt.Errorf("resuming mica client %s: %w", proc.client, err)
+	}
+	if proc.pausedByStop {
+		// the console device went away with the stopped client
+		s.restarted(id, proc)
+	}
+	proc.paused, proc.pausedByStop = false, false
+	proc.journal()
+
+	if proc.companion != nil {
+		if err := proc.companion.resume(ctx); err != nil {
+			return "", err
+		}
+	}
+	return proc.namespace, nil
+}
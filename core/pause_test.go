@@ -0,0 +1,86 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	"mica-shim/libmica"
+)
+
+func TestPauseTask(t *testing.T) {
+	tests := []struct {
+		name string
+		// unknown are the commands micad fails
+		unknown []libmica.MicaCommand
+		byStop  bool
+		state   string
+		err     bool
+	}{
+		{
+			name:  "paused",
+			state: "Paused",
+		},
+		{
+			name:    "stopped",
+			unknown: []libmica.MicaCommand{libmica.MPause},
+			byStop:  true,
+			state:   "Offline",
+		},
+		{
+			name:    "failed",
+			unknown: []libmica.MicaCommand{libmica.MPause, libmica.MStop},
+			state:   clientRunning,
+			err:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			m := newFakeMicad(t)
+			s, proc := newTestTask(t, m, "")
+			for _, cmd := range tt.unknown {
+				m.unknown[cmd] = true
+			}
+			startedAt := proc.startedAt
+
+			ns, err := s.pauseTask(ctx, proc.id)
+			if (err != nil) != tt.err {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if c := m.client(proc.client); c == nil || c.state != tt.state {
+				t.Errorf("expected a %s client, got %+v", tt.state, c)
+			}
+			if tt.err {
+				if proc.paused {
+					t.Errorf("expected the task not to be paused")
+				}
+				return
+			}
+			if ns != "default" || !proc.paused || proc.pausedByStop != tt.byStop {
+				t.Errorf("expected the task to be paused by stop %v, got paused %v by stop %v",
+					tt.byStop, proc.paused, proc.pausedByStop)
+			}
+			// micad not knowing MPause is no micad error
+			if n := proc.micadErrors.Load(); n != 0 {
+				t.Errorf("expected no micad error, got %d", n)
+			}
+			if _, err := s.pauseTask(ctx, proc.id); err == nil {
+				t.Errorf("expected a paused task not to be paused again")
+			}
+
+			if _, err := s.resumeTask(ctx, proc.id); err != nil {
+				t.Fatal(err)
+			}
+			if c := m.client(proc.client); c == nil || c.state != clientRunning {
+				t.Errorf("expected a running client, got %+v", c)
+			}
+			if proc.paused || proc.pausedByStop {
+				t.Errorf("expected the task to be resumed")
+			}
+			// a stopped client boots its firmware again
+			if restarted := !proc.startedAt.Equal(startedAt); restarted != tt.byStop {
+				t.Errorf("expected the firmware restarted %v, got %v", tt.byStop, restarted)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	goio "io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	defs "mica-shim/definitions"
+	"mica-shim/firmware"
+	"mica-shim/io"
+	"mica-shim/libmica"
+	log "mica-shim/logger"
+
+	eventstypes "github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/protobuf"
+	"github.com/containerd/containerd/runtime"
+	"github.com/containerd/containerd/runtime/v2/shim"
+	runc "github.com/containerd/go-runc"
+)
+
+// exitStatusLost is the exit status of a task the shim could not take over
+// from a previous one.
+const exitStatusLost = 253
+
+// recoverTask takes over the task journaled in bundle by a previous shim, if
+// any. The journaled state is reconciled with micad: a task whose client
+// went away or stopped meanwhile is taken over as exited, any other one gets
+// a new init process and its watchers back. Hybrid tasks are not taken over,
+// their companion was run by the previous shim.
+func (s *micaTaskService) recoverTask(bundle string) error {
+	st, err := readState(bundle)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	spec, err := readSpec(bundle)
+	if err != nil {
+		return err
+	}
+	sh, execTimeout, err := execShell(spec)
+	if err != nil {
+		return err
+	}
+	restart, err := taskRestartPolicy(spec)
+	if err != nil {
+		return err
+	}
+	wd, err := taskWatchdog(spec)
+	if err != nil {
+		return err
+	}
+
+	image := firmware.Image{Digest: st.Firmware, Path: st.FirmwarePath}
+	meta, err := firmware.Validate(image.Path)
+	if err != nil {
+		log.WithError(err).Warnf("failed to read firmware %s of task %s", image.Digest, st.ID)
+		meta = &firmware.Metadata{}
+	}
+
+	opts := st.Options
+	if opts == nil {
+		opts = defaultOptions()
+	}
+
+	// the output of an exited task is long closed
+	var stdout, stderr goio.Writer
+	var output goio.Closer = nopCloser{}
+	if st.Status != statusStopped {
+		stdout, stderr, output = openRecoveredOutput(st)
+	}
+
+	con, history, consoleLog, err := openConsole(st.ID, st.Namespace, io.ConsoleConfig{
+		Stdin:        st.Stdin,
+		Stdout:       stdout,
+		Stderr:       stderr,
+		Terminal:     st.Terminal,
+		ResizeEscape: spec.Annotations[defs.AnnotationResizeEscape] == "true",
+	}, opts.consoleLogPath(bundle, st.Namespace, st.ID), opts)
+	if err != nil {
+		output.Close()
+		return err
+	}
+
+	proc := &initProcess{
+		id:            st.ID,
+		pid:           st.Pid,
+		exitTime:      st.ExitedAt,
+		exitStatus:    st.ExitStatus,
+		stdin:         st.Stdin,
+		stdout:        st.Stdout,
+		stderr:        st.Stderr,
+		terminal:      st.Terminal,
+		console:       con,
+		consoleDevice: spec.Annotations[defs.AnnotationConsole],
+		logDevice:     spec.Annotations[defs.AnnotationLogConsole],
+		history:       history,
+		consoleLog:    consoleLog,
+		options:       opts,
+		shell:         sh,
+		execTimeout:   execTimeout,
+		restart:       restart,
+		restarts:      st.Restarts,
+		watchdog:      wd,
+		execs:         make(map[string]*execProcess),
+		bundle:        bundle,
+		rootfs:        st.Rootfs,
+		rootfsMounted: st.RootfsMounted,
+		client:        st.Client,
+		cpu:           st.CPU,
+		startedAt:     st.StartedAt,
+		image:         image,
+		signature:     st.Signature,
+		meta:          meta,
+		namespace:     st.Namespace,
+		paused:        st.Status == statusPaused,
+		pausedByStop:  st.PausedByStop,
+	}
+
+	// the init process of a previous shim may have outlived it
+	if st.Status != statusStopped {
+		killStaleInit(st.Pid)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.procs[st.ID] = proc
+	defer proc.journal()
+
+	if st.Status != statusStopped {
+		if status, reason := reconcileState(st); status != 0 {
+			log.Warnf("mica client %s %s, task %s exited", st.Client, reason, st.ID)
+			proc.lastFailure = fmt.Sprintf("mica client %s %s", st.Client, reason)
+			proc.exitTime, proc.exitStatus = time.Now(), status
+			s.publish(context.Background(), st.Namespace, runtime.TaskExitEventTopic, &eventstypes.TaskExit{
+				ContainerID: st.ID,
+				ID:          st.ID,
+				Pid:         uint32(st.Pid),
+				ExitStatus:  uint32(status),
+				ExitedAt:    protobuf.ToTimestamp(proc.exitTime),
+			})
+		}
+	}
+	if !proc.exitTime.IsZero() {
+		doneCtx, markDone := context.WithCancel(context.Background())
+		markDone()
+		proc.doneCtx = doneCtx
+		if err := con.Close(); err != nil {
+			log.WithError(err).Error("failed to close console")
+		}
+		output.Close()
+		return nil
+	}
+
+	cmd, doneCtx, err := s.runInit(context.Background(), st.ID, st.Namespace, con, output, nil, "")
+	if err != nil {
+		delete(s.procs, st.ID)
+		history.Close()
+		consoleLog.Close()
+		output.Close()
+		return err
+	}
+	proc.pid, proc.doneCtx = cmd.Process.Pid, doneCtx
+	if err := shim.WritePidFile(filepath.Join(bundle, initPidFile), proc.pid); err != nil {
+		log.WithError(err).Warnf("failed to write pid file of task %s", st.ID)
+	}
+	log.Infof("took over task %s with mica client %s, init process %d", st.ID, st.Client, proc.pid)
+
+	if st.Status == statusCreated {
+		return nil
+	}
+	go s.watchClient(st.ID, proc)
+	if proc.watchdog != nil {
+		go s.watchHeartbeat(st.ID, proc)
+	}
+	if !proc.pausedByStop {
+		go s.attachConsole(st.ID, proc)
+	}
+	return nil
+}
+
+// openRecoveredOutput opens the output of a task taken over from a previous
+// shim. Output that cannot be opened in time, e.g. a fifo nobody reads any
+// more, is discarded rather than losing the task.
+func openRecoveredOutput(st *taskState) (stdout, stderr goio.Writer, _ goio.Closer) {
+	ctx, cancel := context.WithTimeout(context.Background(), consoleAttachTimeout)
+	defer cancel()
+
+	stdout, stderr, output, err := io.Output{
+		ID:        st.ID,
+		Namespace: st.Namespace,
+		Stdout:    st.Stdout,
+		Stderr:    st.Stderr,
+	}.Open(ctx)
+	if err != nil {
+		log.WithError(err).Warnf("failed to open stdio of task %s, discarding its output", st.ID)
+		return nil, nil, nopCloser{}
+	}
+	return stdout, stderr, output
+}
+
+// nopCloser is a closer with nothing to close.
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// killStaleInit kills the init process pid of a previous shim, unless it
+// exited and the pid now belongs to another process.
+func killStaleInit(pid int) {
+	if pid <= 0 {
+		return
+	}
+	cmdline, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cmdline"))
+	if err != nil || string(cmdline) != strings.Join(initArgs, "\x00")+"\x00" {
+		return
+	}
+	log.Infof("killing init process %d of the previous shim", pid)
+	if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+		log.WithError(err).Warnf("failed to kill init process %d", pid)
+	}
+}
+
+// reconcileState checks the client of a journaled task against micad. It
+// returns the exit status of a task that cannot be taken over and why, or 0
+// if it can.
+func reconcileState(st *taskState) (int, string) {
+	switch {
+	case st.Hybrid:
+		if libmica.ClientExists(st.Client) {
+			if _, err := libmica.MicaCtl(libmica.MStop, st.Client); err != nil {
+				log.WithError(err).Warnf("failed to stop mica client %s", st.Client)
+			}
+		}
+		if err := newCompanionRuntime(st.Namespace).Delete(context.Background(), st.ID, &runc.DeleteOpts{Force: true}); err != nil {
+			log.WithError(err).Warnf("failed to delete companion of task %s", st.ID)
+		}
+		return exitStatusLost, "lost the companion of its hybrid task"
+	case !libmica.MicadRunning():
+		// micad coming back is the business of watchMicad
+		return 0, ""
+	case !libmica.ClientExists(st.Client):
+		return exitStatusRemoved, "was removed"
+	case st.Status != statusRunning:
+		return 0, ""
+	}
+
+	cs, err := libmica.MicaStatus(st.Client)
+	if err != nil {
+		log.WithError(err).Warnf("failed to get status of mica client %s", st.Client)
+		return 0, ""
+	}
+	if !strings.EqualFold(cs.State, clientRunning) {
+		return exitStatusStopped, "is " + cs.State
+	}
+	return 0, ""
+}
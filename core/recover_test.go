@@ -0,0 +1,38 @@
+package core
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestKillStaleInit(t *testing.T) {
+	placeholder := exec.Command(initArgs[0], initArgs[1:]...)
+	other := exec.Command("sleep", "5")
+	for _, cmd := range []*exec.Cmd{placeholder, other} {
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer other.Process.Kill()
+
+	// give sh the time to exec
+	time.Sleep(100 * time.Millisecond)
+	killStaleInit(placeholder.Process.Pid)
+	killStaleInit(other.Process.Pid)
+
+	if err := placeholder.Wait(); err == nil || placeholder.ProcessState.Success() {
+		t.Errorf("expected the init process to be killed, got %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		other.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Error("killed a process that is not an init process")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	defs "mica-shim/definitions"
+
+	"github.com/containerd/containerd/errdefs"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Restart policies, set by AnnotationRestartPolicy.
+const (
+	restartNever     = "never"
+	restartOnFailure = "on-failure"
+	restartAlways    = "always"
+)
+
+const (
+	// Bounds of the delay before restarting a client, which doubles with
+	// every consecutive restart.
+	restartBackoffMin = time.Second
+	restartBackoffMax = time.Minute
+	// restartBackoffReset is how long a restarted client has to run for its
+	// next failure not to count as a consecutive one.
+	restartBackoffReset = 10 * time.Minute
+)
+
+// restartPolicy tells whether the shim restarts the client of a task that
+// stopped on its own. maxRetries bounds the consecutive restarts of the
+// on-failure policy, unless 0.
+type restartPolicy struct {
+	name       string
+	maxRetries int
+}
+
+// taskRestartPolicy returns the restart policy of a task, as set by
+// annotations.
+func taskRestartPolicy(spec *specs.Spec) (restartPolicy, error) {
+	p := restartPolicy{name: spec.Annotations[defs.AnnotationRestartPolicy]}
+	switch p.name {
+	case "":
+		p.name = restartNever
+	case restartNever, restartOnFailure, restartAlways:
+	default:
+		return p, fmt.Errorf("unknown %s %q: %w", defs.AnnotationRestartPolicy, p.name, errdefs.ErrInvalidArgument)
+	}
+
+	if v := spec.Annotations[defs.AnnotationRestartMaxRetries]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("invalid %s annotation %q: %w", defs.AnnotationRestartMaxRetries, v, errdefs.ErrInvalidArgument)
+		}
+		p.maxRetries = n
+	}
+	return p, nil
+}
+
+// allows tells whether a client lost with the exit status lostStatus is
+// restarted, after failures consecutive restarts.
+func (p restartPolicy) allows(lostStatus, failures int) bool {
+	switch p.name {
+	case restartAlways:
+		return true
+	case restartOnFailure:
+		return lostStatus == exitStatusStopped && (p.maxRetries == 0 || failures < p.maxRetries)
+	}
+	return false
+}
+
+// restartBackoff returns the delay before restarting a client after failures
+// consecutive restarts.
+func restartBackoff(failures int) time.Duration {
+	d := restartBackoffMin
+	for i := 0; i < failures && d < restartBackoffMax; i++ {
+		d *= 2
+	}
+	return min(d, restartBackoffMax)
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	defs "mica-shim/definitions"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestRestartPolicy(t *testing.T) {
+	p, err := taskRestartPolicy(&specs.Spec{})
+	if err != nil || p.allows(exitStatusStopped, 0) {
+		t.Errorf("expected tasks not to restart by default, got %+v: %v", p, err)
+	}
+
+	p, err = taskRestartPolicy(&specs.Spec{Annotations: map[string]string{
+		defs.AnnotationRestartPolicy:     restartOnFailure,
+		defs.AnnotationRestartMaxRetries: "3",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.allows(exitStatusStopped, 2) || p.allows(exitStatusStopped, 3) || p.allows(exitStatusRemoved, 0) {
+		t.Errorf("unexpected on-failure policy %+v", p)
+	}
+
+	p, err = taskRestartPolicy(&specs.Spec{Annotations: map[string]string{
+		defs.AnnotationRestartPolicy: restartAlways,
+	}})
+	if err != nil || !p.allows(exitStatusRemoved, 100) {
+		t.Errorf("unexpected always policy %+v: %v", p, err)
+	}
+
+	if _, err := taskRestartPolicy(&specs.Spec{Annotations: map[string]string{
+		defs.AnnotationRestartPolicy: "unless-stopped",
+	}}); err == nil {
+		t.Error("expected an unknown policy to be rejected")
+	}
+}
+
+func TestRestartBackoff(t *testing.T) {
+	for failures, want := range map[int]time.Duration{
+		0:  time.Second,
+		3:  8 * time.Second,
+		6:  time.Minute,
+		50: time.Minute,
+	} {
+		if d := restartBackoff(failures); d != want {
+			t.Errorf("expected a backoff of %s after %d failures, got %s", want, failures, d)
+		}
+	}
+}
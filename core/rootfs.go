@@ -0,0 +1,148 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	defs "mica-shim/definitions"
+	"mica-shim/firmware"
+	log "mica-shim/logger"
+
+	"github.com/containerd/containerd/api/types"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/continuity/fs"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Name of the OCI runtime spec file inside a bundle.
+const specFile = "config.json"
+
+// readSpec reads the OCI runtime spec of the given bundle.
+func readSpec(bundle string) (*specs.Spec, error) {
+	data, err := os.ReadFile(filepath.Join(bundle, specFile))
+	if err != nil {
+		return nil, fmt.Errorf("reading spec of bundle %s: %w", bundle, err)
+	}
+
+	var spec specs.Spec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("decoding spec of bundle %s: %w", bundle, err)
+	}
+	return &spec, nil
+}
+
+// mountRootfs mounts the rootfs mounts of a task under <bundle>/rootfs and
+// returns that path. If containerd did not send any mount, the bundle is
+// expected to already carry a rootfs at the spec's root.path, which is
+// returned instead and mounted is false.
+func mountRootfs(bundle string, rootfs []*types.Mount, spec *specs.Spec) (path string, mounted bool, _ error) {
+	if len(rootfs) == 0 {
+		if spec.Root == nil || spec.Root.Path == "" {
+			return "", false, fmt.Errorf("no rootfs mounts and no root.path in spec: %w", errdefs.ErrInvalidArgument)
+		}
+		path = spec.Root.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(bundle, path)
+		}
+		return path, false, nil
+	}
+
+	path = filepath.Join(bundle, "rootfs")
+	if err := os.Mkdir(path, 0o711); err != nil && !os.IsExist(err) {
+		return "", false, fmt.Errorf("creating rootfs directory %s: %w", path, err)
+	}
+
+	mounts := mount.FromProto(rootfs)
+	if err := mount.All(mounts, path); err != nil {
+		if uerr := mount.UnmountMounts(mounts, path, 0); uerr != nil {
+			return "", false, fmt.Errorf("mounting rootfs at %s: %w (cleanup: %v)", path, err, uerr)
+		}
+		return "", false, fmt.Errorf("mounting rootfs at %s: %w", path, err)
+	}
+
+	return path, true, nil
+}
+
+// unmountRootfs unmounts everything mounted at the rootfs path of a task.
+func unmountRootfs(path string) error {
+	if err := mount.UnmountAll(path, 0); err != nil {
+		return fmt.Errorf("unmounting rootfs %s: %w", path, err)
+	}
+	return nil
+}
+
+// resolveFirmware returns the host path of the firmware to load for a task.
+// The firmware is taken from the AnnotationFirmware annotation or, failing
+// that, from process.args[0], and is resolved inside rootfs so that absolute
+// paths and symlinks cannot escape it.
+func resolveFirmware(rootfs string, spec *specs.Spec) (string, error) {
+	ref := spec.Annotations[defs.AnnotationFirmware]
+	if ref == "" && spec.Process != nil && len(spec.Process.Args) > 0 {
+		ref = spec.Process.Args[0]
+	}
+	if ref == "" {
+		return "", fmt.Errorf("no firmware in process.args or %s annotation: %w",
+			defs.AnnotationFirmware, errdefs.ErrInvalidArgument)
+	}
+
+	path, err := fs.RootPath(rootfs, ref)
+	if err != nil {
+		return "", fmt.Errorf("resolving firmware %s in rootfs: %w", ref, err)
+	}
+
+	st, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("firmware %s: %w", ref, errdefs.ErrNotFound)
+	}
+	if !st.Mode().IsRegular() {
+		return "", fmt.Errorf("firmware %s is not a regular file: %w", ref, errdefs.ErrInvalidArgument)
+	}
+
+	return path, nil
+}
+
+// admitFirmware checks the stored image of a task's firmware against the
+// host's admission policy and records the decision in an audit log entry.
+// The detached signature is looked up next to the firmware in rootfs; its
+// path is returned, even if there is none.
+func admitFirmware(id, rootfs, fwPath string, image firmware.Image) (string, error) {
+	policy, err := firmware.LoadPolicy(defs.FirmwarePolicyPath)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(rootfs, fwPath)
+	if err != nil {
+		return "", fmt.Errorf("locating firmware %s in rootfs: %w", fwPath, err)
+	}
+	sigPath, err := fs.RootPath(rootfs, rel+firmware.SignatureExt)
+	if err != nil {
+		return "", fmt.Errorf("resolving firmware signature in rootfs: %w", err)
+	}
+
+	audit := log.WithFields(logrus.Fields{
+		"audit":    "firmware-admission",
+		"task":     id,
+		"firmware": "/" + rel,
+		"digest":   image.Digest,
+	})
+
+	reason, err := policy.Admit(image, sigPath)
+	if errors.Is(err, firmware.ErrNotAdmitted) {
+		audit.WithError(err).Warn("firmware rejected")
+		return "", status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err != nil {
+		return "", err
+	}
+
+	audit.WithField("reason", reason).Info("firmware admitted")
+	return sigPath, nil
+}
@@ -0,0 +1,57 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	defs "mica-shim/definitions"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestResolveFirmware(t *testing.T) {
+	rootfs := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(rootfs, "lib/firmware"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(rootfs, "lib/firmware/zephyr.elf")
+	if err := os.WriteFile(want, []byte("elf"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// an absolute symlink must be resolved inside rootfs, not on the host
+	if err := os.Symlink("/lib/firmware/zephyr.elf", filepath.Join(rootfs, "fw")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		spec *specs.Spec
+	}{
+		{"args", &specs.Spec{Process: &specs.Process{Args: []string{"/lib/firmware/zephyr.elf"}}}},
+		{"annotation", &specs.Spec{
+			Process:     &specs.Process{Args: []string{"/bin/sh"}},
+			Annotations: map[string]string{defs.AnnotationFirmware: "lib/firmware/zephyr.elf"},
+		}},
+		{"symlink", &specs.Spec{Process: &specs.Process{Args: []string{"/fw"}}}},
+		{"dotdot", &specs.Spec{Process: &specs.Process{Args: []string{"/../../lib/firmware/zephyr.elf"}}}},
+	}
+
+	for _, tt := range tests {
+		got, err := resolveFirmware(rootfs, tt.spec)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%s: expected %s, got %s", tt.name, want, got)
+		}
+	}
+
+	if _, err := resolveFirmware(rootfs, &specs.Spec{}); err == nil {
+		t.Errorf("expected an error without firmware")
+	}
+	if _, err := resolveFirmware(rootfs, &specs.Spec{Process: &specs.Process{Args: []string{"/lib"}}}); err == nil {
+		t.Errorf("expected an error for a directory")
+	}
+}
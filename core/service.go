@@ -4,13 +4,24 @@ import (
 	"context"
 	"fmt"
 	defs "mica-shim/definitions"
+	micaevents "mica-shim/events"
+	"mica-shim/firmware"
+	"mica-shim/io"
+	"mica-shim/libmica"
 	log "mica-shim/logger"
+	"net"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	taskAPI "github.com/containerd/containerd/api/runtime/task/v2"
+	"github.com/containerd/containerd/events"
+	"github.com/containerd/containerd/namespaces"
 	"github.com/containerd/containerd/pkg/shutdown"
 	"github.com/containerd/containerd/runtime/v2/shim"
+	"github.com/containerd/containerd/sys/reaper"
+	runc "github.com/containerd/go-runc"
 	"github.com/containerd/ttrpc"
 )
 
@@ -25,12 +36,23 @@ func rmSockWhenShutdown(sockAddr string) func(context.Context) error {
 }
 
 // shutdown.Service is used to facilitate shutdown by through callback
-func newTaskService(ss shutdown.Service) (*micaTaskService, error) {
+func newTaskService(publisher shim.Publisher, ss shutdown.Service) (*micaTaskService, error) {
+	store, err := firmware.NewStore(defs.FirmwareStoreDir)
+	if err != nil {
+		return nil, err
+	}
+
 	s := &micaTaskService{
-		procs: make(initProcByTaskID, 1),
-		ss:    ss,
+		procs:     make(initProcByTaskID, 1),
+		store:     store,
+		publisher: publisher,
+		ss:        ss,
 	}
 
+	// runc runs the companions of hybrid tasks, and has to wait for its
+	// commands through the reaper of the shim too
+	runc.Monitor = reaper.Default
+
 	sockAddr, err := shim.ReadAddress(defs.ShimSocketPath)
 	if err != nil {
 		return nil, fmt.Errorf("reading socket address from address file: %w", err)
@@ -38,6 +60,20 @@ func newTaskService(ss shutdown.Service) (*micaTaskService, error) {
 
 	ss.RegisterCallback(rmSockWhenShutdown(sockAddr))
 
+	if id, err := libmica.MicadInstance(); err == nil {
+		s.micadInstance.Store(id)
+	}
+
+	// a task of a previous shim of the bundle is taken over
+	bundle, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("getting current working directory: %w", err)
+	}
+	if err := s.recoverTask(bundle); err != nil {
+		log.WithError(err).Errorf("failed to take over task of bundle %s", bundle)
+	}
+	go s.watchMicad()
+
 	return s, nil
 }
 
@@ -48,11 +84,83 @@ type initProcByTaskID map[string]*initProcess
 // TODO: handle the init process, there it is just a placeholder
 type initProcess struct {
 	// IDEA: for one container pod, make agent process(in Linux) as the init process?
+	id         string
 	pid        int
 	doneCtx    context.Context
 	exitTime   time.Time
 	exitStatus int
+	stdin      string
 	stdout     string
+	stderr     string
+	terminal   bool
+
+	// console bridges the RTOS console, found at consoleDevice or through
+	// micad, and the optional RTOS log channel at logDevice to the task's
+	// stdio. history serves its output to the console-history command and
+	// consoleLog keeps it on disk, as set by options.
+	console       *io.Console
+	consoleDevice string
+	logDevice     string
+	history       net.Listener
+	consoleLog    *io.LogFile
+	options       *Options
+
+	// bundle is the path of the task's bundle.
+	bundle string
+	// rootfs is the path of the task's rootfs; rootfsMounted tells whether
+	// the shim mounted it and has to unmount it on delete.
+	rootfs        string
+	rootfsMounted bool
+	// client is the name of the mica client running image on cpu, whose
+	// firmware last booted at startedAt. micadErrors counts the failed
+	// requests about it.
+	client      string
+	cpu         uint32
+	startedAt   time.Time
+	micadErrors atomic.Uint64
+	image       firmware.Image
+	meta        *firmware.Metadata
+	// signature is the path of the detached signature image was admitted
+	// with, which need not exist.
+	signature string
+	// namespace is the containerd namespace of the task.
+	namespace string
+
+	// execs are the exec processes of the task by exec ID, run in its RTOS
+	// shell with a timeout of execTimeout.
+	execs       map[string]*execProcess
+	shell       io.Shell
+	execTimeout time.Duration
+	// companion is the Linux process of a hybrid task.
+	companion *companion
+
+	// paused tells whether the task is paused. pausedByStop tells that micad
+	// could not pause the core and the client was stopped instead, so that
+	// its firmware restarts on resume.
+	paused       bool
+	pausedByStop bool
+	// stopping tells that the task is being killed, so that its client
+	// stopping is expected. lostStatus is the exit status of a task whose
+	// client stopped on its own, 0 otherwise.
+	stopping   atomic.Bool
+	lostStatus int
+
+	// restart is the restart policy of the task. restarts counts the
+	// restarts of its client, failures the consecutive ones, and
+	// lastFailure tells why the client last stopped. restarting tells that
+	// the lost client waits to be restarted.
+	restart     restartPolicy
+	restarts    int
+	failures    int
+	lastFailure string
+	restarting  bool
+	// watchdog expects a heartbeat from the RTOS, if set.
+	watchdog *watchdog
+	// updating tells that the firmware of the task is being replaced, so
+	// that its client may not run yet. lastFirmwareUpdate is the outcome
+	// of the last replacement.
+	updating           bool
+	lastFirmwareUpdate *micaevents.FirmwareUpdate
 }
 
 // micaTaskService is an implementation of a containerd taskAPI.TaskService
@@ -60,8 +168,17 @@ type initProcess struct {
 type micaTaskService struct {
 	m     sync.RWMutex
 	procs initProcByTaskID
+	store *firmware.Store
 
-	ss shutdown.Service
+	// micadInstance is the micad instance the clients of the tasks were last
+	// reconciled with, 0 while micad is down. micadUnreachable tells that
+	// micad did not answer for a client, so that the clients are reconciled
+	// once it does again.
+	micadInstance    atomic.Uint64
+	micadUnreachable atomic.Bool
+
+	publisher shim.Publisher
+	ss        shutdown.Service
 }
 
 var (
@@ -69,6 +186,14 @@ var (
 	_ taskAPI.TaskService = (*micaTaskService)(nil)
 )
 
+// publish publishes a task event in the namespace ns, logging failures.
+func (s *micaTaskService) publish(ctx context.Context, ns, topic string, event events.Event) {
+	ctx = namespaces.WithNamespace(ctx, ns)
+	if err := s.publisher.Publish(ctx, topic, event); err != nil {
+		log.WithError(err).Warnf("failed to publish %s event", topic)
+	}
+}
+
 // RegisterTTRPC registers this TTRPC service with the given TTRPC server.
 func (s *micaTaskService) RegisterTTRPC(srv *ttrpc.Server) error {
 	taskAPI.RegisterTaskService(srv, s)
@@ -5,6 +5,7 @@ import (
 
 	"github.com/containerd/containerd/pkg/shutdown"
 	"github.com/containerd/containerd/plugin"
+	"github.com/containerd/containerd/runtime/v2/shim"
 )
 
 func ttrpcService(ic *plugin.InitContext) (interface{}, error) {
@@ -12,7 +13,11 @@ func ttrpcService(ic *plugin.InitContext) (interface{}, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to get dependency: shutdown internal plugin: %w", err)
 	}
-	return newTaskService(ss.(shutdown.Service))
+	pp, err := ic.GetByID(plugin.EventPlugin, "publisher")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dependency: publisher event plugin: %w", err)
+	}
+	return newTaskService(pp.(shim.Publisher), ss.(shutdown.Service))
 }
 
 func RegisterPlugin() {
@@ -20,6 +25,7 @@ func RegisterPlugin() {
 		Type: plugin.TTRPCPlugin,
 		ID:   "task",
 		Requires: []plugin.Type{
+			plugin.EventPlugin,
 			plugin.InternalPlugin,
 		},
 		InitFn: ttrpcService,
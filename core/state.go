@@ -0,0 +1,168 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "mica-shim/logger"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+)
+
+// stateFile is the file of the bundle the state of a task is journaled to, so
+// that a new shim can take the task over and the delete command can clean up
+// after a dead one.
+const stateFile = "state.json"
+
+// Statuses of a journaled task.
+const (
+	statusCreated = "created"
+	statusRunning = "running"
+	statusPaused  = "paused"
+	statusStopped = "stopped"
+)
+
+// taskState is the journaled state of a task: what it takes to find its
+// client, stdio and resources again.
+type taskState struct {
+	ID        string `json:"id"`
+	Namespace string `json:"namespace"`
+	Status    string `json:"status"`
+	// Pid is the pid of the placeholder init process.
+	Pid int `json:"pid"`
+
+	Client       string        `json:"client"`
+	CPU          uint32        `json:"cpu"`
+	Firmware     digest.Digest `json:"firmware"`
+	FirmwarePath string        `json:"firmware_path"`
+	Signature    string        `json:"signature,omitempty"`
+	StartedAt    time.Time     `json:"started_at"`
+	PausedByStop bool          `json:"paused_by_stop,omitempty"`
+	Restarts     int           `json:"restarts,omitempty"`
+	Hybrid       bool          `json:"hybrid,omitempty"`
+
+	Rootfs        string `json:"rootfs"`
+	RootfsMounted bool   `json:"rootfs_mounted,omitempty"`
+
+	Stdin    string   `json:"stdin,omitempty"`
+	Stdout   string   `json:"stdout,omitempty"`
+	Stderr   string   `json:"stderr,omitempty"`
+	Terminal bool     `json:"terminal,omitempty"`
+	Options  *Options `json:"options,omitempty"`
+
+	ExitedAt   time.Time `json:"exited_at"`
+	ExitStatus int       `json:"exit_status"`
+}
+
+// writeState journals st into bundle. The state is written to a temporary
+// file first, so that a crash leaves either the old or the new state.
+func writeState(bundle string, st *taskState) error {
+	data, err := json.MarshalIndent(st, "", "\t")
+	if err != nil {
+		return fmt.Errorf("encoding task state: %w", err)
+	}
+
+	f, err := os.CreateTemp(bundle, "."+stateFile+"-")
+	if err != nil {
+		return fmt.Errorf("creating task state: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("writing task state: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("syncing task state: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("writing task state: %w", err)
+	}
+	if err := os.Rename(f.Name(), filepath.Join(bundle, stateFile)); err != nil {
+		return fmt.Errorf("replacing task state: %w", err)
+	}
+
+	// the rename is only durable once the directory is
+	if dir, err := os.Open(bundle); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// readState reads the state journaled in bundle. The error wraps
+// os.ErrNotExist if there is none.
+func readState(bundle string) (*taskState, error) {
+	data, err := os.ReadFile(filepath.Join(bundle, stateFile))
+	if err != nil {
+		return nil, fmt.Errorf("reading task state: %w", err)
+	}
+
+	var st taskState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("decoding task state in %s: %v: %w", bundle, err, errdefs.ErrInvalidArgument)
+	}
+	return &st, nil
+}
+
+// removeState removes the state journaled in bundle.
+func removeState(bundle string) error {
+	err := os.Remove(filepath.Join(bundle, stateFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// state returns the state of the task run by proc.
+func (proc *initProcess) state() *taskState {
+	st := &taskState{
+		ID:            proc.id,
+		Namespace:     proc.namespace,
+		Pid:           proc.pid,
+		Client:        proc.client,
+		CPU:           proc.cpu,
+		Firmware:      proc.image.Digest,
+		FirmwarePath:  proc.image.Path,
+		Signature:     proc.signature,
+		StartedAt:     proc.startedAt,
+		PausedByStop:  proc.pausedByStop,
+		Restarts:      proc.restarts,
+		Hybrid:        proc.companion != nil,
+		Rootfs:        proc.rootfs,
+		RootfsMounted: proc.rootfsMounted,
+		Stdin:         proc.stdin,
+		Stdout:        proc.stdout,
+		Stderr:        proc.stderr,
+		Terminal:      proc.terminal,
+		Options:       proc.options,
+		ExitedAt:      proc.exitTime,
+		ExitStatus:    proc.exitStatus,
+	}
+
+	switch {
+	case !proc.exitTime.IsZero():
+		st.Status = statusStopped
+	case proc.paused:
+		st.Status = statusPaused
+	case !proc.startedAt.IsZero():
+		st.Status = statusRunning
+	default:
+		st.Status = statusCreated
+	}
+	return st
+}
+
+// journal journals the state of the task run by proc into its bundle,
+// logging failures. s.m must be held.
+func (proc *initProcess) journal() {
+	if err := writeState(proc.bundle, proc.state()); err != nil {
+		log.WithError(err).Warnf("failed to journal state of task %s", proc.id)
+	}
+}
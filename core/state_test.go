@@ -0,0 +1,81 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mica-shim/firmware"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestState(t *testing.T) {
+	bundle := t.TempDir()
+	proc := &initProcess{
+		id:        "task",
+		pid:       42,
+		namespace: "default",
+		bundle:    bundle,
+		client:    "task-client",
+		cpu:       3,
+		image:     firmware.Image{Digest: digest.FromString("zephyr"), Path: "/store/zephyr.elf"},
+		stdout:    "/run/stdout",
+		options:   defaultOptions(),
+	}
+
+	for _, tc := range []struct {
+		update func()
+		status string
+	}{
+		{func() {}, statusCreated},
+		{func() { proc.startedAt = time.Now() }, statusRunning},
+		{func() { proc.paused, proc.pausedByStop = true, true }, statusPaused},
+		{func() { proc.exitTime, proc.exitStatus = time.Now(), exitStatusStopped }, statusStopped},
+	} {
+		tc.update()
+		proc.journal()
+
+		st, err := readState(bundle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Status != tc.status {
+			t.Errorf("expected status %s, got %s", tc.status, st.Status)
+		}
+		if st.ID != "task" || st.Pid != 42 || st.Client != "task-client" || st.CPU != 3 ||
+			st.Firmware != proc.image.Digest || st.Stdout != "/run/stdout" || st.Options == nil {
+			t.Errorf("unexpected state %+v", st)
+		}
+	}
+
+	st, _ := readState(bundle)
+	if !st.PausedByStop || st.ExitStatus != exitStatusStopped || !st.ExitedAt.Equal(proc.exitTime) {
+		t.Errorf("unexpected exit state %+v", st)
+	}
+
+	// only the state is left in the bundle
+	entries, err := os.ReadDir(bundle)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("unexpected bundle content %v: %v", entries, err)
+	}
+
+	if err := removeState(bundle); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readState(bundle); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no state, got %v", err)
+	}
+	if err := removeState(bundle); err != nil {
+		t.Errorf("removing missing state: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(bundle, stateFile), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readState(bundle); err == nil {
+		t.Error("expected an error reading a corrupt state")
+	}
+}
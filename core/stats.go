@@ -0,0 +1,52 @@
+package core
+
+import (
+	"time"
+
+	log "mica-shim/logger"
+	"mica-shim/metrics"
+)
+
+// metrics returns the metrics of the task run by proc. s.m must be held.
+func (proc *initProcess) metrics() *metrics.Metrics {
+	m := &metrics.Metrics{
+		Client:      proc.client,
+		Paused:      proc.paused,
+		CPU:         proc.cpu,
+		StartedAt:   proc.startedAt,
+		Restarts:    proc.restarts,
+		LastFailure: proc.lastFailure,
+		Firmware: metrics.Firmware{
+			Digest:     proc.image.Digest.String(),
+			LastUpdate: proc.lastFirmwareUpdate,
+		},
+	}
+	if proc.meta != nil {
+		m.Firmware.BuildID = proc.meta.BuildID
+		m.Firmware.Entry = proc.meta.Entry
+		m.Firmware.Segments = len(proc.meta.Segments)
+	}
+
+	if st, err := proc.micaStatus(); err != nil {
+		log.WithError(err).Warnf("failed to get status of mica client %s", proc.client)
+	} else {
+		m.State = st.State
+	}
+	// micadErrors is read after the status request, which may have failed
+	m.MicadErrors = proc.micadErrors.Load()
+
+	if !proc.startedAt.IsZero() && proc.exitTime.IsZero() && !proc.pausedByStop {
+		m.UptimeSeconds = uint64(time.Since(proc.startedAt).Seconds())
+	}
+
+	stdout, stderr := proc.console.Written()
+	m.Console = metrics.Console{StdoutBytes: uint64(stdout), StderrBytes: uint64(stderr)}
+
+	host, err := metrics.ReadHostCPU(proc.cpu)
+	if err != nil {
+		log.WithError(err).Warnf("failed to read host counters of cpu %d", proc.cpu)
+	}
+	m.Host = host
+
+	return m
+}
@@ -0,0 +1,254 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+
+	defs "mica-shim/definitions"
+	"mica-shim/events"
+	"mica-shim/firmware"
+	"mica-shim/libmica"
+	log "mica-shim/logger"
+
+	"github.com/containerd/containerd/errdefs"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// defaultFirmwareUpdateTimeout bounds how long a replaced firmware may take
+// to run, unless set by AnnotationFirmwareUpdateTimeout.
+const defaultFirmwareUpdateTimeout = 10 * time.Second
+
+// clientRunning is the state micad reports for a running client.
+const clientRunning = "Running"
+
+// firmwareUpdateTimeout returns the timeout of a firmware update requested
+// with annotations.
+func firmwareUpdateTimeout(annotations map[string]string) (time.Duration, error) {
+	t := annotations[defs.AnnotationFirmwareUpdateTimeout]
+	if t == "" {
+		return defaultFirmwareUpdateTimeout, nil
+	}
+	d, err := time.ParseDuration(t)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s annotation %q: %w", defs.AnnotationFirmwareUpdateTimeout, t, errdefs.ErrInvalidArgument)
+	}
+	return d, nil
+}
+
+// replaceFirmware replaces the firmware of a task with the one annotations
+// refer to in its rootfs. The new image is checked as at create, then the
+// client is recreated with it and, if the task was started, restarted. If
+// the new firmware does not run within the timeout, the previous one is
+// reinstated; if that fails too, the task is stopped. s.m is not held while
+// waiting for the new firmware to run.
+//
+// It returns the namespace of the task and the event telling the outcome,
+// nil if the firmware did not change, along with the error of the new
+// firmware.
+func (s *micaTaskService) replaceFirmware(id string, annotations map[string]string) (string, *events.FirmwareUpdate, error) {
+	timeout, err := firmwareUpdateTimeout(annotations)
+	if err != nil {
+		return "", nil, err
+	}
+
+	proc, u, err := s.startFirmwareUpdate(id, annotations)
+	if err != nil || u == nil {
+		return "", nil, err
+	}
+
+	// the client watchers hold off while the update runs, see proc.updating
+	if u.err == nil && u.running {
+		u.err = waitRunning(proc, timeout)
+	}
+	return proc.namespace, u.event, s.finishFirmwareUpdate(id, proc, u)
+}
+
+// firmwareUpdate is a running firmware update of a task.
+type firmwareUpdate struct {
+	event        *events.FirmwareUpdate
+	old          firmware.Image
+	oldMeta      *firmware.Metadata
+	oldSignature string
+	// running tells whether the task was started, and err why the new
+	// firmware failed.
+	running bool
+	err     error
+}
+
+// startFirmwareUpdate recreates the client of a task with the firmware
+// annotations refer to. It returns a nil update if the firmware does not
+// change.
+func (s *micaTaskService) startFirmwareUpdate(id string, annotations map[string]string) (*initProcess, *firmwareUpdate, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	proc, ok := s.procs[id]
+	if !ok {
+		return nil, nil, fmt.Errorf("task not created: %w", errdefs.ErrNotFound)
+	}
+	if !proc.exitTime.IsZero() {
+		return nil, nil, fmt.Errorf("task %s is not running: %w", id, errdefs.ErrFailedPrecondition)
+	}
+	if proc.paused {
+		return nil, nil, fmt.Errorf("task %s is paused: %w", id, errdefs.ErrFailedPrecondition)
+	}
+	if proc.updating {
+		return nil, nil, fmt.Errorf("firmware of task %s is being updated: %w", id, errdefs.ErrFailedPrecondition)
+	}
+
+	image, meta, signature, err := s.addFirmware(id, proc, annotations)
+	if err != nil {
+		return nil, nil, err
+	}
+	if image.Digest == proc.image.Digest {
+		return nil, nil, nil
+	}
+
+	u := &firmwareUpdate{
+		event: &events.FirmwareUpdate{
+			ContainerID: id,
+			Firmware:    image.Digest.String(),
+			Previous:    proc.image.Digest.String(),
+		},
+		old:          proc.image,
+		oldMeta:      proc.meta,
+		oldSignature: proc.signature,
+		running:      !proc.startedAt.IsZero(),
+	}
+	if err := s.removeClient(id, proc, u.running); err != nil {
+		s.releaseFirmware(image, proc.namespace+"/"+id)
+		return nil, nil, err
+	}
+
+	proc.image, proc.meta, proc.signature = image, meta, signature
+	log.Infof("replacing firmware %s of mica client %s with %s", u.old.Digest, proc.client, image.Digest)
+	u.err = s.recreateClient(id, proc, proc.cpu, u.running)
+	proc.updating = true
+	return proc, u, nil
+}
+
+// finishFirmwareUpdate keeps the new firmware of a task if it runs, or else
+// reinstates the previous one. It sets the outcome of the update and returns
+// the error of the new firmware.
+func (s *micaTaskService) finishFirmwareUpdate(id string, proc *initProcess, u *firmwareUpdate) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	defer func() {
+		// a task deleted meanwhile has no journal any more
+		if s.procs[id] == proc {
+			proc.journal()
+		}
+	}()
+	proc.updating = false
+	proc.lastFirmwareUpdate = u.event
+
+	owner := proc.namespace + "/" + id
+	// a task killed meanwhile keeps the new firmware to be released on delete
+	if u.err == nil || !proc.exitTime.IsZero() || proc.stopping.Load() {
+		s.releaseFirmware(u.old, owner)
+		u.event.Outcome = events.FirmwareUpdated
+		if u.err != nil {
+			u.event.Outcome = events.FirmwareFailed
+			u.event.Error = u.err.Error()
+		}
+		return u.err
+	}
+
+	image := proc.image
+	// a client that could not be created again is gone already
+	if libmica.ClientExists(proc.client) {
+		if u.running {
+			if err := proc.micaCtl(libmica.MStop); err != nil {
+				log.WithError(err).Warnf("failed to stop mica client %s", proc.client)
+			}
+		}
+		if err := proc.micaCtl(libmica.MRemove); err != nil {
+			log.WithError(err).Warnf("failed to remove mica client %s", proc.client)
+		}
+	}
+
+	log.WithError(u.err).Warnf("firmware %s of mica client %s failed, reinstating %s",
+		image.Digest, proc.client, u.old.Digest)
+	updateErr := fmt.Errorf("replacing firmware of mica client %s: %w", proc.client, u.err)
+	u.event.Error = updateErr.Error()
+	proc.image, proc.meta, proc.signature = u.old, u.oldMeta, u.oldSignature
+	s.releaseFirmware(image, owner)
+
+	if err := s.recreateClient(id, proc, proc.cpu, u.running); err != nil {
+		log.WithError(err).Errorf("failed to reinstate firmware of mica client %s, stopping task %s", proc.client, id)
+		if err := syscall.Kill(proc.pid, syscall.SIGKILL); err != nil {
+			log.WithError(err).Errorf("failed to kill init process %d", proc.pid)
+		}
+		u.event.Outcome = events.FirmwareFailed
+		return errors.Join(updateErr, err)
+	}
+	u.event.Outcome = events.FirmwareRolledBack
+	return updateErr
+}
+
+// addFirmware stores the firmware annotations refer to in the rootfs of a
+// task, and checks it as at create. It returns the image along with its
+// metadata and the path of the signature it was admitted with. s.m must be
+// held.
+func (s *micaTaskService) addFirmware(id string, proc *initProcess, annotations map[string]string) (_ firmware.Image, _ *firmware.Metadata, signature string, retErr error) {
+	fwPath, err := resolveFirmware(proc.rootfs, &specs.Spec{Annotations: annotations})
+	if err != nil {
+		return firmware.Image{}, nil, "", err
+	}
+
+	owner := proc.namespace + "/" + id
+	image, err := s.store.Add(fwPath, owner)
+	if err != nil {
+		return firmware.Image{}, nil, "", fmt.Errorf("storing firmware %s: %w", fwPath, err)
+	}
+	if image.Digest == proc.image.Digest {
+		return image, proc.meta, proc.signature, nil
+	}
+	defer func() {
+		if retErr != nil {
+			s.releaseFirmware(image, owner)
+		}
+	}()
+
+	// only admitted firmware gets parsed
+	signature, err = admitFirmware(id, proc.rootfs, fwPath, image)
+	if err != nil {
+		return firmware.Image{}, nil, "", err
+	}
+	meta, err := firmware.Validate(image.Path)
+	if err != nil {
+		return firmware.Image{}, nil, "", fmt.Errorf("validating firmware %s: %w", fwPath, err)
+	}
+	if len(image.Path) >= libmica.MaxPathLen {
+		return firmware.Image{}, nil, "", fmt.Errorf("firmware path %s is longer than %d bytes: %w",
+			image.Path, libmica.MaxPathLen-1, errdefs.ErrInvalidArgument)
+	}
+	return image, meta, signature, nil
+}
+
+// releaseFirmware drops the reference of owner on image, logging failures.
+func (s *micaTaskService) releaseFirmware(image firmware.Image, owner string) {
+	if err := s.store.Release(image.Digest, owner); err != nil {
+		log.WithError(err).Errorf("failed to release firmware %s", image.Digest)
+	}
+}
+
+// waitRunning waits for micad to report the mica client of proc as running.
+func waitRunning(proc *initProcess, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		st, err := proc.micaStatus()
+		if err == nil && strings.EqualFold(st.State, clientRunning) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("mica client %s not running after %s: %w", proc.client, timeout, err)
+			}
+			return fmt.Errorf("mica client %s is %s after %s", proc.client, st.State, timeout)
+		}
+		time.Sleep(consolePollInterval)
+	}
+}
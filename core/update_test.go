@@ -0,0 +1,201 @@
+package core
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+
+	defs "mica-shim/definitions"
+	"mica-shim/events"
+)
+
+// elfMachines maps GOARCH to the ELF machine of the host cores, which test
+// firmware is built for.
+var elfMachines = map[string]elf.Machine{
+	"386":     elf.EM_386,
+	"amd64":   elf.EM_X86_64,
+	"arm":     elf.EM_ARM,
+	"arm64":   elf.EM_AARCH64,
+	"loong64": elf.EM_LOONGARCH,
+	"riscv64": elf.EM_RISCV,
+}
+
+// writeFirmware writes to path the smallest firmware passing
+// firmware.Validate, whose code is filled with fill so that firmwares differ.
+func writeFirmware(t *testing.T, path string, fill byte) {
+	t.Helper()
+
+	const (
+		segOff   = 0x100
+		shOff    = 0x180
+		dataSize = 16
+	)
+	shstrtab := []byte("\x00.resource_table\x00.shstrtab\x00")
+
+	var buf bytes.Buffer
+	hdr := elf.Header64{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(elfMachines[runtime.GOARCH]),
+		Version:   uint32(elf.EV_CURRENT),
+		Entry:     0x1000,
+		Phoff:     64,
+		Shoff:     shOff,
+		Ehsize:    64,
+		Phentsize: 56,
+		Phnum:     1,
+		Shentsize: 64,
+		Shnum:     3,
+		Shstrndx:  2,
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	binary.Write(&buf, binary.LittleEndian, hdr)
+	binary.Write(&buf, binary.LittleEndian, elf.Prog64{
+		Type:   uint32(elf.PT_LOAD),
+		Flags:  uint32(elf.PF_R | elf.PF_X),
+		Off:    segOff,
+		Vaddr:  0x1000,
+		Paddr:  0x1000,
+		Filesz: dataSize,
+		Memsz:  dataSize,
+		Align:  0x1000,
+	})
+
+	buf.Write(make([]byte, segOff-buf.Len()))
+	buf.Write(bytes.Repeat([]byte{fill}, dataSize))
+	strOff := buf.Len()
+	buf.Write(shstrtab)
+	buf.Write(make([]byte, shOff-buf.Len()))
+
+	binary.Write(&buf, binary.LittleEndian, elf.Section64{})
+	binary.Write(&buf, binary.LittleEndian, elf.Section64{
+		Name: 1, Type: uint32(elf.SHT_PROGBITS), Off: segOff, Size: dataSize, Addralign: 1,
+	})
+	binary.Write(&buf, binary.LittleEndian, elf.Section64{
+		Name: 17, Type: uint32(elf.SHT_STRTAB), Off: uint64(strOff), Size: uint64(len(shstrtab)), Addralign: 1,
+	})
+
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplaceFirmware(t *testing.T) {
+	tests := []struct {
+		name string
+		// boots and fails are the firmwares, old or new, that run once
+		// started and that micad fails to start
+		boots, fails string
+		// kill has the task killed while the new firmware is waited for
+		kill    bool
+		err     bool
+		outcome string
+		// current is the firmware the task runs after the update
+		current string
+	}{
+		{
+			name:    "updated",
+			boots:   "old new",
+			outcome: events.FirmwareUpdated,
+			current: "new",
+		},
+		{
+			name:    "rolled back",
+			boots:   "old",
+			err:     true,
+			outcome: events.FirmwareRolledBack,
+			current: "old",
+		},
+		{
+			name:    "failed",
+			boots:   "old",
+			fails:   "old new",
+			err:     true,
+			outcome: events.FirmwareFailed,
+			current: "old",
+		},
+		{
+			name:    "killed",
+			boots:   "old",
+			kill:    true,
+			err:     true,
+			outcome: events.FirmwareFailed,
+			current: "new",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fw := t.TempDir()
+			writeFirmware(t, filepath.Join(fw, "old.elf"), 0x90)
+			m := newFakeMicad(t)
+			s, proc := newTestTask(t, m, filepath.Join(fw, "old.elf"))
+			old := proc.image
+
+			writeFirmware(t, filepath.Join(proc.rootfs, "new.elf"), 0x91)
+			// micad gets the paths of the stored images, named after their digest
+			is := func(names string) func(string) bool {
+				return func(path string) bool {
+					name := "new"
+					if path == old.Path {
+						name = "old"
+					}
+					return slices.Contains(strings.Fields(names), name)
+				}
+			}
+			m.boots, m.fails = is(tt.boots), is(tt.fails)
+			if tt.kill {
+				m.onStatus = func(string) {
+					s.m.Lock()
+					defer s.m.Unlock()
+					proc.stopping.Store(true)
+				}
+			}
+
+			ns, event, err := s.replaceFirmware(proc.id, map[string]string{
+				defs.AnnotationFirmware:              "/new.elf",
+				defs.AnnotationFirmwareUpdateTimeout: "200ms",
+			})
+			if (err != nil) != tt.err {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if ns != "default" || event == nil || event.Outcome != tt.outcome || event.Previous != old.Digest.String() {
+				t.Fatalf("expected a %s update of firmware %s, got %+v", tt.outcome, old.Digest, event)
+			}
+			if proc.updating {
+				t.Errorf("expected the update to be done")
+			}
+
+			current := map[string]string{"old": event.Previous, "new": event.Firmware}[tt.current]
+			if proc.image.Digest.String() != current {
+				t.Errorf("expected the task to run the %s firmware %s, got %s", tt.current, current, proc.image.Digest)
+			}
+			// only the current firmware is kept
+			if _, err := os.Stat(proc.image.Path); err != nil {
+				t.Errorf("expected the current firmware to be kept: %v", err)
+			}
+			if owners, err := s.store.Owners(); err != nil || len(owners) != 1 {
+				t.Errorf("expected the other firmware to be released, got owners %v: %v", owners, err)
+			}
+
+			c := m.client(proc.client)
+			switch {
+			case tt.outcome == events.FirmwareFailed && !tt.kill:
+				if c != nil || !killed(t, proc.pid) {
+					t.Errorf("expected the task to be stopped without client, got %+v", c)
+				}
+			case c == nil || c.path != proc.image.Path:
+				t.Errorf("expected a client running %s, got %+v", proc.image.Path, c)
+			case tt.outcome != events.FirmwareFailed && c.state != clientRunning:
+				t.Errorf("expected the client to run, got %s", c.state)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+
+	"mica-shim/libmica"
+	log "mica-shim/logger"
+)
+
+// watchInterval is how often the client of a started task is checked.
+const watchInterval = 2 * time.Second
+
+// Exit statuses of tasks whose client stopped on its own, out of the range of
+// the 128+signal statuses of killed tasks.
+const (
+	// exitStatusStopped is the exit status of a task whose RTOS stopped
+	// running, e.g. on a crash.
+	exitStatusStopped = 250
+	// exitStatusRemoved is the exit status of a task whose client micad
+	// removed.
+	exitStatusRemoved = 251
+)
+
+// watchClient checks the client of a started task until it exits. If the
+// client stops running or goes away while not expected to, it is restarted as
+// the restart policy of the task allows, or else the task exits with a
+// status telling why.
+func (s *micaTaskService) watchClient(id string, proc *initProcess) {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-proc.doneCtx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		lost, restartIn := s.checkClient(id, proc)
+		if !lost {
+			continue
+		}
+		if restartIn == 0 {
+			return
+		}
+
+		select {
+		case <-proc.doneCtx.Done():
+			return
+		case <-time.After(restartIn):
+		}
+		s.restartClient(id, proc)
+	}
+}
+
+// checkClient checks the client of a task and tells whether it was lost. A
+// lost client is to be restarted after restartIn, or else the task is
+// stopped and restartIn is 0. s.m is not held while asking micad and
+// collecting crash data, the task is checked again before acting.
+func (s *micaTaskService) checkClient(id string, proc *initProcess) (lost bool, restartIn time.Duration) {
+	s.m.RLock()
+	watched, startedAt, src := proc.watched(), proc.startedAt, proc.crashSource()
+	s.m.RUnlock()
+	// micad being down or restarted is not a reason to give up on its
+	// clients, see watchMicad
+	if !watched {
+		return false, 0
+	}
+	if id, err := libmica.MicadInstance(); err != nil || id != s.micadInstance.Load() || s.micadUnreachable.Load() {
+		return false, 0
+	}
+
+	var reason string
+	var status int
+	st, err := libmica.MicaStatus(src.client)
+	switch {
+	case err == nil && strings.EqualFold(st.State, clientRunning):
+	case err == nil:
+		reason, status = "is "+st.State, exitStatusStopped
+	case !libmica.ClientExists(src.client):
+		reason, status = "was removed", exitStatusRemoved
+	case libmica.Unreachable(err):
+		proc.micadErrors.Add(1)
+		log.WithError(err).Warnf("micad does not answer for mica client %s", src.client)
+		s.micadUnreachable.Store(true)
+		return false, 0
+	default:
+		proc.micadErrors.Add(1)
+		log.WithError(err).Warnf("failed to get status of mica client %s", src.client)
+		return false, 0
+	}
+
+	// the crash data of the remote processor goes with a restart
+	if status == exitStatusStopped {
+		recordCrash(src, fmt.Sprintf("mica client %s %s", src.client, reason), status)
+	}
+	return s.clientChecked(id, proc, startedAt, reason, status)
+}
+
+// clientChecked acts on the check of the client of a task started at
+// startedAt, which stopped as reason tells with status, or runs if status
+// is 0. Nothing is done if the task changed meanwhile, e.g. was stopped or
+// restarted. It returns what checkClient does.
+func (s *micaTaskService) clientChecked(id string, proc *initProcess, startedAt time.Time, reason string, status int) (lost bool, restartIn time.Duration) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.procs[id] != proc || !proc.watched() || !proc.startedAt.Equal(startedAt) {
+		return false, 0
+	}
+
+	if status == 0 {
+		if proc.failures > 0 && time.Since(proc.startedAt) > restartBackoffReset {
+			proc.failures = 0
+		}
+		return false, 0
+	}
+
+	proc.lastFailure = fmt.Sprintf("mica client %s %s", proc.client, reason)
+	if proc.restart.allows(status, proc.failures) {
+		restartIn = restartBackoff(proc.failures)
+		proc.failures++
+		proc.restarting = true
+		log.Warnf("%s, restarting task %s in %s", proc.lastFailure, id, restartIn)
+		return true, restartIn
+	}
+
+	log.Warnf("%s, stopping task %s", proc.lastFailure, id)
+	proc.lostStatus = status
+	if err := syscall.Kill(proc.pid, syscall.SIGKILL); err != nil {
+		log.WithError(err).Errorf("failed to kill init process %d", proc.pid)
+	}
+	return true, 0
+}
+
+// watched tells whether the client of proc is expected to run, and so is
+// watched. Clients are stopped on purpose by Kill, Pause and firmware
+// updates. s.m must be held.
+func (proc *initProcess) watched() bool {
+	return proc.exitTime.IsZero() && !proc.paused && !proc.stopping.Load() && !proc.updating
+}
+
+// restartClient restarts the lost client of a task, creating it again on its
+// core if micad removed it. A failed restart is noticed by the next check.
+func (s *micaTaskService) restartClient(id string, proc *initProcess) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	proc.restarting = false
+	if !proc.watched() {
+		return
+	}
+
+	if !libmica.ClientExists(proc.client) {
+		if err := proc.micaCreate(proc.cpu); err != nil {
+			log.WithError(err).Warnf("failed to create mica client %s again", proc.client)
+			return
+		}
+	}
+	if err := proc.micaCtl(libmica.MStart); err != nil {
+		log.WithError(err).Warnf("failed to restart mica client %s", proc.client)
+		return
+	}
+	proc.restarts++
+	s.restarted(id, proc)
+}
+
+// exited records the exit of the init process of a task and returns its exit
+// time. It tells whether the task was still there.
+func (s *micaTaskService) exited(id string, status int) (time.Time, bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	proc, ok := s.procs[id]
+	if !ok {
+		return time.Time{}, false
+	}
+	proc.exitTime = time.Now()
+	proc.exitStatus = status
+	proc.journal()
+
+	// the RTOS shell is gone with the client
+	for _, ep := range proc.execs {
+		if ep.cancel != nil {
+			ep.cancel()
+		}
+	}
+	return proc.exitTime, true
+}
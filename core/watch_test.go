@@ -0,0 +1,105 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckClient(t *testing.T) {
+	defer func(sys string) { sysfsRoot = sys }(sysfsRoot)
+	sysfsRoot = t.TempDir()
+
+	tests := []struct {
+		name      string
+		policy    string
+		lose      func(m *fakeMicad, client string)
+		lost      bool
+		restart   bool
+		status    int
+		crashData bool
+	}{
+		{
+			name: "running",
+			lose: func(*fakeMicad, string) {},
+		},
+		{
+			name:      "crashed, restarted",
+			policy:    restartAlways,
+			lose:      func(m *fakeMicad, client string) { m.setState(client, "Crashed") },
+			lost:      true,
+			restart:   true,
+			crashData: true,
+		},
+		{
+			name:      "crashed, stopped",
+			policy:    restartNever,
+			lose:      func(m *fakeMicad, client string) { m.setState(client, "Crashed") },
+			lost:      true,
+			status:    exitStatusStopped,
+			crashData: true,
+		},
+		{
+			name:   "removed",
+			policy: restartOnFailure,
+			lose:   func(m *fakeMicad, client string) { m.removeClient(client) },
+			lost:   true,
+			status: exitStatusRemoved,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newFakeMicad(t)
+			s, proc := newTestTask(t, m, "")
+			proc.restart = restartPolicy{name: tt.policy}
+			tt.lose(m, proc.client)
+
+			lost, restartIn := s.checkClient(proc.id, proc)
+			if lost != tt.lost || (restartIn != 0) != tt.restart {
+				t.Fatalf("expected lost %v and restart %v, got %v and %s", tt.lost, tt.restart, lost, restartIn)
+			}
+			if tt.restart && (restartIn != restartBackoffMin || proc.failures != 1) {
+				t.Errorf("expected a restart in %s after 1 failure, got %s after %d", restartBackoffMin, restartIn, proc.failures)
+			}
+			if proc.lostStatus != tt.status {
+				t.Errorf("expected lost status %d, got %d", tt.status, proc.lostStatus)
+			}
+			if k := killed(t, proc.pid); k != (tt.status != 0) {
+				t.Errorf("expected init killed %v, got %v", tt.status != 0, k)
+			}
+			crashes, _ := os.ReadDir(filepath.Join(proc.bundle, crashDir))
+			if (len(crashes) == 1) != tt.crashData {
+				t.Errorf("expected crash data %v, got %d crash directories", tt.crashData, len(crashes))
+			}
+		})
+	}
+}
+
+func TestCheckClientSkipped(t *testing.T) {
+	m := newFakeMicad(t)
+	s, proc := newTestTask(t, m, "")
+	m.setState(proc.client, "Crashed")
+
+	proc.paused = true
+	if lost, _ := s.checkClient(proc.id, proc); lost {
+		t.Errorf("expected the client of a paused task not to be lost")
+	}
+	proc.paused = false
+
+	// the task is stopped while micad is asked, the check must not hold s.m
+	// then and must not act on the task after
+	m.onStatus = func(string) {
+		s.m.Lock()
+		defer s.m.Unlock()
+		proc.stopping.Store(true)
+	}
+	if lost, _ := s.checkClient(proc.id, proc); lost {
+		t.Errorf("expected the client of a stopping task not to be lost")
+	}
+	if proc.lostStatus != 0 || proc.lastFailure != "" || killed(t, proc.pid) {
+		t.Errorf("expected the stopping task to be left alone, got status %d and failure %q", proc.lostStatus, proc.lastFailure)
+	}
+	if c := m.client(proc.client); c == nil || c.state != "Crashed" {
+		t.Errorf("expected the client to be left alone, got %+v", c)
+	}
+}
@@ -0,0 +1,141 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	goio "io"
+	"os"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	defs "mica-shim/definitions"
+	"mica-shim/io"
+	"mica-shim/libmica"
+	log "mica-shim/logger"
+
+	"github.com/containerd/containerd/errdefs"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// defaultWatchdogTimeout is how long an RTOS may go without a heartbeat,
+// unless set by AnnotationWatchdogTimeout.
+const defaultWatchdogTimeout = 30 * time.Second
+
+// exitStatusHung is the exit status of a task whose RTOS missed its
+// heartbeat.
+const exitStatusHung = 252
+
+// watchdog expects a periodic heartbeat from an RTOS, on its console or on
+// the RPMsg endpoint device.
+type watchdog struct {
+	heartbeat io.Heartbeat
+	device    string
+}
+
+// taskWatchdog returns the heartbeat watchdog of a task as set by
+// annotations, nil if it has none.
+func taskWatchdog(spec *specs.Spec) (*watchdog, error) {
+	pattern := spec.Annotations[defs.AnnotationWatchdogPattern]
+	device := spec.Annotations[defs.AnnotationWatchdogDevice]
+	if pattern == "" && device == "" {
+		return nil, nil
+	}
+
+	w := &watchdog{heartbeat: io.Heartbeat{Timeout: defaultWatchdogTimeout}, device: device}
+	if device == "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: %v: %w", defs.AnnotationWatchdogPattern, err, errdefs.ErrInvalidArgument)
+		}
+		w.heartbeat.Pattern = re
+	}
+	if t := spec.Annotations[defs.AnnotationWatchdogTimeout]; t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid %s annotation %q: %w", defs.AnnotationWatchdogTimeout, t, errdefs.ErrInvalidArgument)
+		}
+		w.heartbeat.Timeout = d
+	}
+	return w, nil
+}
+
+// watchHeartbeat fails a started task once its RTOS misses its heartbeat,
+// unless the task is paused or being stopped.
+func (s *micaTaskService) watchHeartbeat(id string, proc *initProcess) {
+	for {
+		r := proc.watchdog.open(proc)
+		err := proc.watchdog.heartbeat.Watch(proc.doneCtx, r)
+		r.Close()
+		if !errors.Is(err, io.ErrHeartbeatMissed) {
+			return
+		}
+		if s.heartbeatMissed(id, proc, err) {
+			return
+		}
+	}
+}
+
+// open opens the heartbeat stream of the RTOS of proc. Without its device
+// the stream is empty, so that the heartbeat is missed. The device is opened
+// non-blocking, so that closing it ends a pending read.
+func (w *watchdog) open(proc *initProcess) goio.ReadCloser {
+	if w.device == "" {
+		return proc.console.NewReader()
+	}
+	f, err := os.OpenFile(w.device, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		log.WithError(err).Warnf("failed to open heartbeat device of mica client %s", proc.client)
+		return goio.NopCloser(strings.NewReader(""))
+	}
+	return f
+}
+
+// heartbeatMissed fails a task whose RTOS missed its heartbeat with err. An
+// RTOS is not expected to beat while its client is paused, being updated or
+// restarted, does not run, or booted within the timeout; it is watched again
+// then. s.m is not held while asking micad and collecting crash data. It
+// tells whether the watchdog is done.
+func (s *micaTaskService) heartbeatMissed(id string, proc *initProcess, err error) bool {
+	s.m.RLock()
+	done := !proc.exitTime.IsZero() || proc.stopping.Load()
+	beats, startedAt, src := proc.beats(), proc.startedAt, proc.crashSource()
+	s.m.RUnlock()
+	if done {
+		return true
+	}
+	if !beats || time.Since(startedAt) < proc.watchdog.heartbeat.Timeout {
+		return false
+	}
+	// a client that does not run is left to watchClient
+	if st, err := libmica.MicaStatus(src.client); err != nil || !strings.EqualFold(st.State, clientRunning) {
+		return false
+	}
+
+	failure := fmt.Sprintf("mica client %s: %v", src.client, err)
+	recordCrash(src, failure, exitStatusHung)
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	if !proc.exitTime.IsZero() || proc.stopping.Load() {
+		return true
+	}
+	if !proc.beats() || !proc.startedAt.Equal(startedAt) {
+		return false
+	}
+
+	proc.lastFailure = failure
+	log.Warnf("%s, stopping task %s", proc.lastFailure, id)
+	proc.lostStatus = exitStatusHung
+	if err := syscall.Kill(proc.pid, syscall.SIGKILL); err != nil {
+		log.WithError(err).Errorf("failed to kill init process %d", proc.pid)
+	}
+	return true
+}
+
+// beats tells whether the RTOS of proc is expected to beat, as its client is
+// watched and not waiting to be restarted. s.m must be held.
+func (proc *initProcess) beats() bool {
+	return proc.watched() && !proc.restarting
+}
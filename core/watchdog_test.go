@@ -0,0 +1,76 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"mica-shim/io"
+)
+
+func TestHeartbeatMissed(t *testing.T) {
+	defer func(sys string) { sysfsRoot = sys }(sysfsRoot)
+	sysfsRoot = t.TempDir()
+
+	m := newFakeMicad(t)
+	s, proc := newTestTask(t, m, "")
+	proc.watchdog = &watchdog{heartbeat: io.Heartbeat{Timeout: time.Second}}
+	missed := io.ErrHeartbeatMissed
+
+	// a client booted within the timeout, waiting to be restarted or that
+	// does not run is not failed
+	if s.heartbeatMissed(proc.id, proc, missed) || proc.lostStatus != 0 {
+		t.Fatalf("expected the heartbeat of a booting client to be watched again")
+	}
+	proc.startedAt = proc.startedAt.Add(-time.Second)
+	proc.restarting = true
+	if s.heartbeatMissed(proc.id, proc, missed) || proc.lostStatus != 0 {
+		t.Fatalf("expected the heartbeat of a restarting client to be watched again")
+	}
+	proc.restarting = false
+	m.setState(proc.client, "Crashed")
+	if s.heartbeatMissed(proc.id, proc, missed) || proc.lostStatus != 0 {
+		t.Fatalf("expected the heartbeat of a crashed client to be watched again")
+	}
+
+	m.setState(proc.client, clientRunning)
+	if !s.heartbeatMissed(proc.id, proc, missed) || proc.lostStatus != exitStatusHung || !killed(t, proc.pid) {
+		t.Errorf("expected a hung task to be stopped, got status %d", proc.lostStatus)
+	}
+}
+
+func TestWatchdogDeviceClose(t *testing.T) {
+	// a fifo nobody writes to stands in for a quiet heartbeat device
+	dev := t.TempDir() + "/rpmsg"
+	if err := syscall.Mkfifo(dev, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	w, err := os.OpenFile(dev, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	r := (&watchdog{device: dev}).open(&initProcess{})
+	read := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		read <- err
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	time.Sleep(10 * time.Millisecond)
+	r.Close()
+	select {
+	case err := <-read:
+		if !errors.Is(err, os.ErrClosed) {
+			t.Errorf("expected the read to end with the device closed, got %v", err)
+		}
+	case <-ctx.Done():
+		t.Errorf("read of the heartbeat device still pending after close")
+	}
+}
@@ -0,0 +1,58 @@
+package defs
+
+// Annotations understood by the shim. They are read from the OCI spec of the
+// task's bundle and all share MicaAnnotationPrefix.
+const (
+	// AnnotationFirmware overrides process.args[0] as the firmware path,
+	// relative to the container rootfs.
+	AnnotationFirmware = MicaAnnotationPrefix + ".firmware"
+	// AnnotationCPU selects the CPU the RTOS client runs on, overriding the
+	// first CPU of linux.resources.cpu.cpus.
+	AnnotationCPU = MicaAnnotationPrefix + ".cpu"
+	// AnnotationConsole sets the host path of the RTOS console device,
+	// instead of the RPMsg TTY micad reports for the client.
+	AnnotationConsole = MicaAnnotationPrefix + ".console"
+	// AnnotationLogConsole sets the host path of a device carrying the RTOS
+	// log channel, whose output goes to the task's stderr.
+	AnnotationLogConsole = MicaAnnotationPrefix + ".console.log"
+	// AnnotationResizeEscape, when "true", forwards terminal resizes to the
+	// RTOS as xterm window size escape sequences.
+	AnnotationResizeEscape = MicaAnnotationPrefix + ".console.resize-escape"
+	// AnnotationShellPrompt is a regular expression matching the prompt of
+	// the RTOS shell, which ends the output of an exec process.
+	AnnotationShellPrompt = MicaAnnotationPrefix + ".shell.prompt"
+	// AnnotationShellStatus is the RTOS shell command printing the return
+	// value of the previous command, e.g. "retval" on Zephyr.
+	AnnotationShellStatus = MicaAnnotationPrefix + ".shell.status-command"
+	// AnnotationShellTimeout bounds how long an exec process waits for the
+	// RTOS shell to prompt again, as a Go duration.
+	AnnotationShellTimeout = MicaAnnotationPrefix + ".shell.timeout"
+	// AnnotationHybrid, when "true", runs the OCI process of the task as a
+	// Linux companion of the RTOS, whose firmware is then set by
+	// AnnotationFirmware.
+	AnnotationHybrid = MicaAnnotationPrefix + ".hybrid"
+	// AnnotationHybridExitPolicy tells when a hybrid task exits: "any" once
+	// the RTOS or the companion exits, the default, or "all" once both did.
+	AnnotationHybridExitPolicy = MicaAnnotationPrefix + ".hybrid.exit-policy"
+	// AnnotationFirmwareUpdateTimeout bounds how long a firmware set by
+	// AnnotationFirmware in an Update request may take to run, as a Go
+	// duration, before the previous one is reinstated.
+	AnnotationFirmwareUpdateTimeout = MicaAnnotationPrefix + ".firmware.update-timeout"
+	// AnnotationRestartPolicy tells whether the shim restarts an RTOS that
+	// stopped on its own: "never", the default, "on-failure" when it stopped
+	// running, or "always", also when micad removed its client.
+	AnnotationRestartPolicy = MicaAnnotationPrefix + ".restart.policy"
+	// AnnotationRestartMaxRetries bounds the consecutive restarts of the
+	// on-failure policy, unlimited if 0, the default.
+	AnnotationRestartMaxRetries = MicaAnnotationPrefix + ".restart.max-retries"
+	// AnnotationWatchdogPattern is a regular expression matching the
+	// heartbeat lines the RTOS prints on its console. Setting it or
+	// AnnotationWatchdogDevice enables the heartbeat watchdog.
+	AnnotationWatchdogPattern = MicaAnnotationPrefix + ".watchdog.pattern"
+	// AnnotationWatchdogDevice is an RPMsg endpoint device every message of
+	// which is a heartbeat, instead of the console.
+	AnnotationWatchdogDevice = MicaAnnotationPrefix + ".watchdog.device"
+	// AnnotationWatchdogTimeout is how long the RTOS may go without a
+	// heartbeat before its task fails, as a Go duration.
+	AnnotationWatchdogTimeout = MicaAnnotationPrefix + ".watchdog.timeout"
+)
@@ -4,7 +4,11 @@
 package defs
 
 const (
-	ShimSocketPath = "/tmp/mica-shim.sock"
-	MicaConfDir    = "/etc/mica"
-	MicaSocketDir  = "/run/mica"
+	ShimSocketPath     = "/tmp/mica-shim.sock"
+	MicaConfDir        = "/etc/mica"
+	MicaSocketDir      = "/run/mica"
+	FirmwareStoreDir   = "/var/lib/mica/firmware"
+	ShimStateDir       = "/run/mica-shim"
+	ConsoleLogDir      = "/var/log/mica"
+	FirmwarePolicyPath = "/etc/mica/firmware-policy.json"
 )
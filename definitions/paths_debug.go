@@ -4,7 +4,11 @@
 package defs
 
 const (
-	ShimSocketPath = "/tmp/mica-shim.sock"
-	MicaConfDir    = "/tmp/mica"
-	MicaSocketDir  = "/tmp/mica"
+	ShimSocketPath     = "/tmp/mica-shim.sock"
+	MicaConfDir        = "/tmp/mica"
+	MicaSocketDir      = "/tmp/mica"
+	FirmwareStoreDir   = "/tmp/mica/firmware"
+	ShimStateDir       = "/tmp/mica-shim"
+	ConsoleLogDir      = "/tmp/mica/log"
+	FirmwarePolicyPath = "/tmp/mica/firmware-policy.json"
 )
@@ -0,0 +1,39 @@
+// Package events defines the events the shim publishes for mica tasks, next
+// to the task events of containerd.
+package events
+
+import (
+	"github.com/containerd/typeurl/v2"
+)
+
+// TopicFirmwareUpdate is the topic of FirmwareUpdate events.
+const TopicFirmwareUpdate = "/tasks/mica/firmware-update"
+
+// Outcomes of a firmware update.
+const (
+	// FirmwareUpdated tells that the task runs the new firmware.
+	FirmwareUpdated = "updated"
+	// FirmwareRolledBack tells that the new firmware failed and that the
+	// task runs its previous firmware again.
+	FirmwareRolledBack = "rolled-back"
+	// FirmwareFailed tells that neither firmware could be brought up, and
+	// that the task was stopped.
+	FirmwareFailed = "failed"
+)
+
+func init() {
+	typeurl.Register(&FirmwareUpdate{}, "org.openeuler.mica/events/v1/FirmwareUpdate")
+}
+
+// FirmwareUpdate is published once the firmware of a task was replaced
+// through the Update RPC of the shim, or failed to be.
+type FirmwareUpdate struct {
+	ContainerID string `json:"container_id"`
+	// Firmware is the digest of the new firmware and Previous the one of
+	// the firmware it replaced.
+	Firmware string `json:"firmware"`
+	Previous string `json:"previous"`
+	Outcome  string `json:"outcome"`
+	// Error tells why the new firmware failed, if it did.
+	Error string `json:"error,omitempty"`
+}
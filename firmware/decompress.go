@@ -0,0 +1,93 @@
+package firmware
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/ulikunitz/xz"
+)
+
+// MaxImageSize caps the size of a firmware image once decompressed, so that
+// a corrupt or malicious image cannot fill the host's disk.
+const MaxImageSize = 64 << 20
+
+// Compression formats of firmware images, detected by their magic bytes.
+const (
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+	CompressionXz   = "xz"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// image is a firmware image opened for reading its decompressed content.
+type image struct {
+	io.Reader
+	f           *os.File
+	compression string
+}
+
+func (i *image) Close() error {
+	return i.f.Close()
+}
+
+// openImage opens the firmware at path and transparently decompresses it if
+// it is a gzip or xz stream, whatever its extension.
+func openImage(path string) (*image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening firmware %s: %w", path, err)
+	}
+
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(len(xzMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		f.Close()
+		return nil, fmt.Errorf("reading firmware %s: %w", path, err)
+	}
+
+	img := &image{Reader: br, f: f}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("reading gzip firmware %s (%v): %w", path, err, errdefs.ErrInvalidArgument)
+		}
+		img.Reader, img.compression = zr, CompressionGzip
+	case bytes.HasPrefix(magic, xzMagic):
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("reading xz firmware %s (%v): %w", path, err, errdefs.ErrInvalidArgument)
+		}
+		img.Reader, img.compression = xr, CompressionXz
+	}
+
+	return img, nil
+}
+
+// copyImage copies the content of img to w, failing once more than max bytes
+// have been copied.
+func copyImage(w io.Writer, img *image, max int64) error {
+	n, err := io.Copy(w, io.LimitReader(img, max+1))
+	if err != nil {
+		if img.compression != CompressionNone {
+			return fmt.Errorf("decompressing %s firmware (%v): %w", img.compression, err, errdefs.ErrInvalidArgument)
+		}
+		return err
+	}
+	if n > max {
+		return fmt.Errorf("firmware is larger than %d bytes: %w", max, errdefs.ErrInvalidArgument)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package firmware
+
+import (
+	"debug/elf"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/containerd/containerd/errdefs"
+)
+
+// resourceTableSection is the section remoteproc reads the resources the
+// firmware needs (carveouts, vdevs, trace buffers) from.
+const resourceTableSection = ".resource_table"
+
+// NT_GNU_BUILD_ID note type, see elf.h.
+const ntGNUBuildID = 3
+
+// hostMachines maps GOARCH to the ELF machine of the host CPU cores.
+var hostMachines = map[string]elf.Machine{
+	"386":     elf.EM_386,
+	"amd64":   elf.EM_X86_64,
+	"arm":     elf.EM_ARM,
+	"arm64":   elf.EM_AARCH64,
+	"loong64": elf.EM_LOONGARCH,
+	"riscv64": elf.EM_RISCV,
+}
+
+// Metadata describes a validated firmware image.
+type Metadata struct {
+	Machine elf.Machine
+	Entry   uint64
+	// BuildID is the hex encoded GNU build-id, empty if the image has none.
+	BuildID  string
+	Segments []Segment
+}
+
+// Segment is a loadable segment of a firmware image.
+type Segment struct {
+	Vaddr  uint64
+	Paddr  uint64
+	Filesz uint64
+	Memsz  uint64
+	Flags  elf.ProgFlag
+}
+
+// Validate checks that the ELF image at path can be loaded by remoteproc on
+// one of the host's cores and returns its metadata. Images that cannot are
+// rejected with an errdefs.ErrInvalidArgument error.
+func Validate(path string) (*Metadata, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("checking firmware %s: %w", path, err)
+	}
+
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("firmware is not a valid ELF image (%v): %w", err, errdefs.ErrInvalidArgument)
+	}
+	defer f.Close()
+
+	if want, ok := hostMachines[runtime.GOARCH]; ok && f.Machine != want {
+		return nil, fmt.Errorf("firmware is built for %s, host cores are %s: %w",
+			f.Machine, want, errdefs.ErrInvalidArgument)
+	}
+
+	meta := &Metadata{
+		Machine: f.Machine,
+		Entry:   f.Entry,
+	}
+
+	entryLoaded := false
+	for _, p := range f.Progs {
+		if p.Type != elf.PT_LOAD || p.Memsz == 0 {
+			continue
+		}
+		if p.Off+p.Filesz > uint64(st.Size()) {
+			return nil, fmt.Errorf("firmware is truncated: segment at 0x%x needs %d bytes, file has %d: %w",
+				p.Vaddr, p.Off+p.Filesz, st.Size(), errdefs.ErrInvalidArgument)
+		}
+		if inSegment(f.Entry, p.Vaddr, p.Memsz) || inSegment(f.Entry, p.Paddr, p.Memsz) {
+			entryLoaded = true
+		}
+		meta.Segments = append(meta.Segments, Segment{
+			Vaddr:  p.Vaddr,
+			Paddr:  p.Paddr,
+			Filesz: p.Filesz,
+			Memsz:  p.Memsz,
+			Flags:  p.Flags,
+		})
+	}
+
+	if len(meta.Segments) == 0 {
+		return nil, fmt.Errorf("firmware has no loadable segment: %w", errdefs.ErrInvalidArgument)
+	}
+	if !entryLoaded {
+		return nil, fmt.Errorf("firmware entry point 0x%x is outside its loadable segments: %w",
+			f.Entry, errdefs.ErrInvalidArgument)
+	}
+	if f.Section(resourceTableSection) == nil {
+		return nil, fmt.Errorf("firmware has no %s section, remoteproc cannot load it: %w",
+			resourceTableSection, errdefs.ErrInvalidArgument)
+	}
+
+	meta.BuildID = buildID(f)
+
+	return meta, nil
+}
+
+// inSegment tells whether addr lies in the segment of size bytes at start.
+func inSegment(addr, start, size uint64) bool {
+	return addr >= start && addr-start < size
+}
+
+// buildID returns the hex encoded GNU build-id note of f, if any.
+func buildID(f *elf.File) string {
+	for _, s := range f.Sections {
+		if s.Type != elf.SHT_NOTE {
+			continue
+		}
+		data, err := s.Data()
+		if err != nil {
+			continue
+		}
+		for len(data) >= 12 {
+			namesz := f.ByteOrder.Uint32(data[0:4])
+			descsz := f.ByteOrder.Uint32(data[4:8])
+			typ := f.ByteOrder.Uint32(data[8:12])
+			nameEnd := 12 + align4(namesz)
+			descEnd := nameEnd + align4(descsz)
+			if descEnd > uint64(len(data)) {
+				break
+			}
+			if typ == ntGNUBuildID && string(data[12:12+namesz]) == "GNU\x00" {
+				return hex.EncodeToString(data[nameEnd : nameEnd+uint64(descsz)])
+			}
+			data = data[descEnd:]
+		}
+	}
+	return ""
+}
+
+// align4 rounds n up to the 4 bytes alignment of ELF notes.
+func align4(n uint32) uint64 {
+	return (uint64(n) + 3) &^ 3
+}
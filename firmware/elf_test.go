@@ -0,0 +1,146 @@
+package firmware
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+)
+
+// testELF describes a minimal ELF64 image written by writeTestELF.
+type testELF struct {
+	machine       elf.Machine
+	entry         uint64
+	resourceTable bool
+	// truncate cuts the image short of its loadable segment
+	truncate bool
+}
+
+func validTestELF() testELF {
+	return testELF{
+		machine:       hostMachines[runtime.GOARCH],
+		entry:         0x1000,
+		resourceTable: true,
+	}
+}
+
+// writeTestELF writes an image with one 16 bytes PT_LOAD segment at 0x1000
+// and returns its path.
+func writeTestELF(t *testing.T, e testELF) string {
+	t.Helper()
+
+	const (
+		segOff   = 0x100
+		rscOff   = 0x110
+		strOff   = 0x120
+		shOff    = 0x180
+		dataSize = 16
+	)
+	shstrtab := []byte("\x00.resource_table\x00.shstrtab\x00")
+
+	var buf bytes.Buffer
+	hdr := elf.Header64{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(e.machine),
+		Version:   uint32(elf.EV_CURRENT),
+		Entry:     e.entry,
+		Phoff:     64,
+		Shoff:     shOff,
+		Ehsize:    64,
+		Phentsize: 56,
+		Phnum:     1,
+		Shentsize: 64,
+		Shnum:     3,
+		Shstrndx:  2,
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	binary.Write(&buf, binary.LittleEndian, hdr)
+
+	binary.Write(&buf, binary.LittleEndian, elf.Prog64{
+		Type:   uint32(elf.PT_LOAD),
+		Flags:  uint32(elf.PF_R | elf.PF_X),
+		Off:    segOff,
+		Vaddr:  0x1000,
+		Paddr:  0x1000,
+		Filesz: dataSize,
+		Memsz:  dataSize,
+		Align:  0x1000,
+	})
+
+	buf.Write(make([]byte, segOff-buf.Len()))
+	buf.Write(bytes.Repeat([]byte{0x90}, dataSize))
+	buf.Write(make([]byte, dataSize))
+	buf.Write(shstrtab)
+	buf.Write(make([]byte, shOff-buf.Len()))
+
+	rscName := uint32(1)
+	if !e.resourceTable {
+		rscName = 10 // "_table"
+	}
+	binary.Write(&buf, binary.LittleEndian, elf.Section64{})
+	binary.Write(&buf, binary.LittleEndian, elf.Section64{
+		Name: rscName, Type: uint32(elf.SHT_PROGBITS), Off: rscOff, Size: dataSize, Addralign: 1,
+	})
+	binary.Write(&buf, binary.LittleEndian, elf.Section64{
+		Name: 17, Type: uint32(elf.SHT_STRTAB), Off: strOff, Size: uint64(len(shstrtab)), Addralign: 1,
+	})
+
+	data := buf.Bytes()
+	if e.truncate {
+		// keep the program headers readable, but drop the section headers
+		// and the end of the segment data
+		data = data[:segOff+dataSize/2]
+		hdr.Shnum, hdr.Shoff, hdr.Shstrndx = 0, 0, 0
+		var h bytes.Buffer
+		binary.Write(&h, binary.LittleEndian, hdr)
+		copy(data, h.Bytes())
+	}
+
+	path := filepath.Join(t.TempDir(), "zephyr.elf")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestValidate(t *testing.T) {
+	if _, ok := hostMachines[runtime.GOARCH]; !ok {
+		t.Skipf("no ELF machine known for %s", runtime.GOARCH)
+	}
+
+	meta, err := Validate(writeTestELF(t, validTestELF()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if meta.Entry != 0x1000 || len(meta.Segments) != 1 || meta.Segments[0].Memsz != 16 {
+		t.Errorf("unexpected metadata %+v", meta)
+	}
+
+	other := elf.EM_AARCH64
+	if runtime.GOARCH == "arm64" {
+		other = elf.EM_X86_64
+	}
+
+	invalid := map[string]func(*testELF){
+		"machine":        func(e *testELF) { e.machine = other },
+		"entry":          func(e *testELF) { e.entry = 0x2000 },
+		"resource table": func(e *testELF) { e.resourceTable = false },
+		"truncated":      func(e *testELF) { e.truncate = true },
+	}
+	for name, mutate := range invalid {
+		e := validTestELF()
+		mutate(&e)
+		if _, err := Validate(writeTestELF(t, e)); !errors.Is(err, errdefs.ErrInvalidArgument) {
+			t.Errorf("%s: expected an invalid argument error, got %v", name, err)
+		}
+	}
+}
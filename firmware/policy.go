@@ -0,0 +1,156 @@
+package firmware
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// SignatureExt is appended to the path of a firmware image to find its
+// detached signature.
+const SignatureExt = ".sig"
+
+// ErrNotAdmitted is returned for firmware images the admission policy rejects.
+var ErrNotAdmitted = errors.New("firmware not admitted")
+
+// Policy is a firmware admission policy, read from a local JSON file such as:
+//
+//	{
+//	  "digests": ["sha256:4f5c..."],
+//	  "keys": ["keys/release.pub"]
+//	}
+//
+// An image is admitted if its digest is listed, or if its detached signature
+// verifies with one of the ed25519 public keys. Keys are PEM encoded PKIX
+// files; relative paths are resolved from the directory of the policy.
+type Policy struct {
+	Digests []digest.Digest `json:"digests"`
+	Keys    []string        `json:"keys"`
+
+	keys []ed25519.PublicKey
+}
+
+// LoadPolicy reads the admission policy at path. It returns a nil Policy,
+// which admits everything, when there is no such file.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading firmware policy: %w", err)
+	}
+
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("decoding firmware policy %s: %w", path, err)
+	}
+
+	for _, d := range p.Digests {
+		if err := d.Validate(); err != nil {
+			return nil, fmt.Errorf("firmware policy %s: digest %q: %w", path, d, err)
+		}
+	}
+
+	for _, k := range p.Keys {
+		if !filepath.IsAbs(k) {
+			k = filepath.Join(filepath.Dir(path), k)
+		}
+		key, err := readPublicKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("firmware policy %s: %w", path, err)
+		}
+		p.keys = append(p.keys, key)
+	}
+
+	return &p, nil
+}
+
+// Admit checks img against the policy. sigPath is the detached signature
+// shipped with the image, which may not exist. The returned reason explains
+// the decision; rejected images get an ErrNotAdmitted error.
+func (p *Policy) Admit(img Image, sigPath string) (reason string, _ error) {
+	if p == nil {
+		return "no admission policy", nil
+	}
+
+	for _, d := range p.Digests {
+		if d == img.Digest {
+			return "digest is allowed", nil
+		}
+	}
+
+	if len(p.keys) == 0 {
+		return "", fmt.Errorf("digest %s is not allowed: %w", img.Digest, ErrNotAdmitted)
+	}
+
+	sig, err := readSignature(sigPath)
+	if err != nil {
+		return "", fmt.Errorf("digest %s is not allowed and %v: %w", img.Digest, err, ErrNotAdmitted)
+	}
+
+	data, err := os.ReadFile(img.Path)
+	if err != nil {
+		return "", fmt.Errorf("reading firmware %s: %w", img.Digest, err)
+	}
+
+	for i, key := range p.keys {
+		if ed25519.Verify(key, data, sig) {
+			return fmt.Sprintf("signed with key %s", p.Keys[i]), nil
+		}
+	}
+
+	return "", fmt.Errorf("digest %s is not allowed and no key verifies its signature: %w", img.Digest, ErrNotAdmitted)
+}
+
+// readPublicKey reads a PEM encoded ed25519 public key.
+func readPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %s is not PEM encoded", path)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing key %s: %w", path, err)
+	}
+	key, ok := pub.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("key %s is not an ed25519 key", path)
+	}
+	return key, nil
+}
+
+// readSignature reads a detached signature, either raw or base64 encoded.
+// Signatures are computed over the decompressed image.
+func readSignature(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errors.New("it has no signature")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading its signature: %w", err)
+	}
+
+	if len(data) == ed25519.SignatureSize {
+		return data, nil
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, errors.New("its signature is malformed")
+	}
+	return sig, nil
+}
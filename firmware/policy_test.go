@@ -0,0 +1,80 @@
+package firmware
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestPolicyAdmit(t *testing.T) {
+	dir := t.TempDir()
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "release.pub"),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	content := []byte("firmware")
+	img := Image{Digest: digest.FromBytes(content), Path: filepath.Join(dir, "zephyr.elf")}
+	if err := os.WriteFile(img.Path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sigPath := img.Path + SignatureExt
+
+	if p, err := LoadPolicy(filepath.Join(dir, "missing.json")); err != nil || p != nil {
+		t.Fatalf("expected no policy, got %v, %v", p, err)
+	}
+
+	writePolicy := func(policy string) *Policy {
+		path := filepath.Join(dir, "policy.json")
+		if err := os.WriteFile(path, []byte(policy), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		p, err := LoadPolicy(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	p := writePolicy(fmt.Sprintf(`{"digests": ["%s"]}`, img.Digest))
+	if _, err := p.Admit(img, sigPath); err != nil {
+		t.Errorf("expected allowed digest to be admitted, got %v", err)
+	}
+
+	p = writePolicy(`{"keys": ["release.pub"]}`)
+	if _, err := p.Admit(img, sigPath); !errors.Is(err, ErrNotAdmitted) {
+		t.Errorf("expected unsigned firmware to be rejected, got %v", err)
+	}
+
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, content))
+	if err := os.WriteFile(sigPath, []byte(sig+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Admit(img, sigPath); err != nil {
+		t.Errorf("expected signed firmware to be admitted, got %v", err)
+	}
+
+	if err := os.WriteFile(sigPath, ed25519.Sign(priv, []byte("other")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Admit(img, sigPath); !errors.Is(err, ErrNotAdmitted) {
+		t.Errorf("expected a bad signature to be rejected, got %v", err)
+	}
+}
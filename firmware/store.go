@@ -0,0 +1,220 @@
+package firmware
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	refsDir  = "refs"
+	lockFile = ".lock"
+	imageExt = ".elf"
+)
+
+// Image is a firmware image held in a Store.
+type Image struct {
+	Digest digest.Digest
+	// Path is the host path of the image, as handed to micad.
+	Path string
+	// Compression is the format the image was shipped in, CompressionNone
+	// if it was a plain ELF.
+	Compression string
+}
+
+// Store is a content-addressed store of firmware images on the host.
+//
+// Images are kept as <dir>/<sha256>.elf: a short, stable path that fits in
+// micad's create message and stays readable after the container rootfs has
+// been unmounted. Every image is reference-counted by owner (usually one
+// task) and removed once its last owner releases it. A store directory is
+// shared by all shims on the host, so changes are serialized with a file lock.
+//
+// Compressed images are decompressed on the way in, up to maxSize bytes.
+type Store struct {
+	dir     string
+	maxSize int64
+}
+
+// NewStore returns a Store rooted at dir, creating it if needed.
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Join(dir, refsDir), 0o755); err != nil {
+		return nil, fmt.Errorf("creating firmware store %s: %w", dir, err)
+	}
+	return &Store{dir: dir, maxSize: MaxImageSize}, nil
+}
+
+// Add copies the firmware at src into the store, decompressing it if needed,
+// unless an identical image is already there, and records owner as one of
+// its references.
+func (s *Store) Add(src, owner string) (Image, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return Image{}, err
+	}
+	defer unlock()
+
+	img, err := openImage(src)
+	if err != nil {
+		return Image{}, err
+	}
+	defer img.Close()
+
+	tmp, err := os.CreateTemp(s.dir, ".ingest-")
+	if err != nil {
+		return Image{}, fmt.Errorf("creating ingest file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	// images are stored and digested decompressed, that is as micad and
+	// the validation read them
+	digester := digest.Canonical.Digester()
+	if err := copyImage(io.MultiWriter(tmp, digester.Hash()), img, s.maxSize); err != nil {
+		tmp.Close()
+		return Image{}, fmt.Errorf("copying firmware %s: %w", src, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return Image{}, fmt.Errorf("closing ingest file: %w", err)
+	}
+
+	stored := Image{
+		Digest:      digester.Digest(),
+		Compression: img.compression,
+	}
+	stored.Path = s.path(stored.Digest)
+
+	if _, err := os.Stat(stored.Path); errors.Is(err, os.ErrNotExist) {
+		if err := os.Chmod(tmp.Name(), 0o444); err != nil {
+			return Image{}, fmt.Errorf("setting firmware permissions: %w", err)
+		}
+		if err := os.Rename(tmp.Name(), stored.Path); err != nil {
+			return Image{}, fmt.Errorf("committing firmware %s: %w", stored.Digest, err)
+		}
+	} else if err != nil {
+		return Image{}, fmt.Errorf("checking firmware %s: %w", stored.Digest, err)
+	}
+
+	refs := filepath.Join(s.dir, refsDir, stored.Digest.Encoded())
+	if err := os.MkdirAll(refs, 0o755); err != nil {
+		return Image{}, fmt.Errorf("creating references of firmware %s: %w", stored.Digest, err)
+	}
+	if err := os.WriteFile(filepath.Join(refs, url.PathEscape(owner)), nil, 0o644); err != nil {
+		return Image{}, fmt.Errorf("referencing firmware %s: %w", stored.Digest, err)
+	}
+
+	return stored, nil
+}
+
+// Release drops the reference of owner on the image with digest dgst and
+// removes the image once no owner is left. Releasing an unknown reference is
+// not an error.
+func (s *Store) Release(dgst digest.Digest, owner string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return s.release(dgst, owner)
+}
+
+// ReleaseOwner drops the references of owner on all images, removing the
+// ones it was the last reference of. It is how the references of an owner
+// that went away without releasing them are collected.
+func (s *Store) ReleaseOwner(owner string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	refs, err := filepath.Glob(filepath.Join(s.dir, refsDir, "*"))
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, ref := range refs {
+		if _, err := os.Stat(filepath.Join(ref, url.PathEscape(owner))); err != nil {
+			continue
+		}
+		dgst := digest.NewDigestFromEncoded(digest.Canonical, filepath.Base(ref))
+		if err := s.release(dgst, owner); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// release drops the reference of owner on the image with digest dgst. The
+// store lock must be held.
+func (s *Store) release(dgst digest.Digest, owner string) error {
+	refs := filepath.Join(s.dir, refsDir, dgst.Encoded())
+	if err := os.Remove(filepath.Join(refs, url.PathEscape(owner))); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("releasing firmware %s: %w", dgst, err)
+	}
+
+	entries, err := os.ReadDir(refs)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("reading references of firmware %s: %w", dgst, err)
+	}
+	if len(entries) > 0 {
+		return nil
+	}
+
+	if err := os.Remove(refs); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing references of firmware %s: %w", dgst, err)
+	}
+	if err := os.Remove(s.path(dgst)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing firmware %s: %w", dgst, err)
+	}
+	return nil
+}
+
+// Owners returns the owners holding a reference on any image of the store.
+func (s *Store) Owners() ([]string, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	refs, err := filepath.Glob(filepath.Join(s.dir, refsDir, "*", "*"))
+	if err != nil {
+		return nil, err
+	}
+	var owners []string
+	for _, ref := range refs {
+		owner, err := url.PathUnescape(filepath.Base(ref))
+		if err != nil {
+			continue
+		}
+		owners = append(owners, owner)
+	}
+	return owners, nil
+}
+
+// path returns the path of the image with digest dgst.
+func (s *Store) path(dgst digest.Digest) string {
+	return filepath.Join(s.dir, dgst.Encoded()+imageExt)
+}
+
+// lock takes the store-wide lock and returns a function releasing it.
+func (s *Store) lock() (func(), error) {
+	f, err := os.OpenFile(filepath.Join(s.dir, lockFile), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening firmware store lock: %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("locking firmware store: %w", err)
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
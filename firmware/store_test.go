@@ -0,0 +1,164 @@
+package firmware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/ulikunitz/xz"
+)
+
+func TestStoreRefCount(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewStore(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := filepath.Join(dir, "zephyr.elf")
+	if err := os.WriteFile(src, []byte("firmware"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := s.Add(src, "default/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.Add(src, "default/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b {
+		t.Errorf("expected identical firmware to be deduplicated, got %v and %v", a, b)
+	}
+	if owners, err := s.Owners(); err != nil || len(owners) != 2 || owners[0] != "default/a" || owners[1] != "default/b" {
+		t.Errorf("unexpected owners %v: %v", owners, err)
+	}
+	if want := filepath.Join(dir, "store", a.Digest.Encoded()+".elf"); a.Path != want {
+		t.Errorf("expected path %s, got %s", want, a.Path)
+	}
+	if data, err := os.ReadFile(a.Path); err != nil || string(data) != "firmware" {
+		t.Errorf("unexpected stored firmware %q: %v", data, err)
+	}
+
+	if err := s.Release(a.Digest, "default/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(a.Path); err != nil {
+		t.Errorf("firmware removed while still referenced: %v", err)
+	}
+
+	// releasing twice must not drop the other reference
+	if err := s.Release(a.Digest, "default/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(a.Path); err != nil {
+		t.Errorf("firmware removed while still referenced: %v", err)
+	}
+
+	if err := s.Release(b.Digest, "default/b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(a.Path); !os.IsNotExist(err) {
+		t.Errorf("expected unreferenced firmware to be removed, got %v", err)
+	}
+}
+
+func TestStoreReleaseOwner(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewStore(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var images []Image
+	for _, fw := range []string{"zephyr", "uniproton"} {
+		src := filepath.Join(dir, fw+".elf")
+		if err := os.WriteFile(src, []byte(fw), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		img, err := s.Add(src, "default/gone")
+		if err != nil {
+			t.Fatal(err)
+		}
+		images = append(images, img)
+	}
+	// the old firmware of an updated task is shared with another one
+	if _, err := s.Add(filepath.Join(dir, "zephyr.elf"), "default/live"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ReleaseOwner("default/gone"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(images[0].Path); err != nil {
+		t.Errorf("firmware removed while still referenced: %v", err)
+	}
+	if _, err := os.Stat(images[1].Path); !os.IsNotExist(err) {
+		t.Errorf("expected unreferenced firmware to be removed, got %v", err)
+	}
+	if owners, err := s.Owners(); err != nil || len(owners) != 1 || owners[0] != "default/live" {
+		t.Errorf("unexpected owners %v: %v", owners, err)
+	}
+
+	if err := s.ReleaseOwner("default/unknown"); err != nil {
+		t.Errorf("releasing an unknown owner: %v", err)
+	}
+}
+
+func TestStoreDecompress(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewStore(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := bytes.Repeat([]byte("firmware"), 64)
+	raw := filepath.Join(dir, "zephyr.elf")
+	if err := os.WriteFile(raw, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want, err := s.Add(raw, "default/raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(content)
+	zw.Close()
+
+	var xzb bytes.Buffer
+	xw, err := xz.NewWriter(&xzb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xw.Write(content)
+	xw.Close()
+
+	// the format comes from the magic bytes, not from the extension
+	for compression, data := range map[string][]byte{CompressionGzip: gz.Bytes(), CompressionXz: xzb.Bytes()} {
+		src := filepath.Join(dir, compression+".elf")
+		if err := os.WriteFile(src, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		img, err := s.Add(src, "default/"+compression)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", compression, err)
+			continue
+		}
+		if img.Digest != want.Digest || img.Compression != compression {
+			t.Errorf("%s: expected digest %s, got %s (%q)", compression, want.Digest, img.Digest, img.Compression)
+		}
+	}
+
+	s.maxSize = int64(len(content)) - 1
+	src := filepath.Join(dir, "gzip.elf")
+	if _, err := s.Add(src, "default/big"); !errors.Is(err, errdefs.ErrInvalidArgument) {
+		t.Errorf("expected images over the size cap to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,392 @@
+package io
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	log "mica-shim/logger"
+
+	"github.com/containerd/console"
+	"github.com/containerd/fifo"
+)
+
+// eot is the end-of-transmission character (^D), which an RTOS shell reads
+// as end of input.
+const eot = 0x04
+
+// drainTimeout bounds how long a detaching console waits for its remaining
+// output to be forwarded.
+const drainTimeout = time.Second
+
+// Console bridges the console of an RTOS client, usually an RPMsg TTY such as
+// /dev/ttyRPMSG0, to the stdio of a task: the device output is copied into
+// its stdout and the stdin named pipe is copied into the device. The output
+// of an optional second device, such as the RTOS log channel, is copied into
+// its stderr. Any file can stand in for the devices, so tests can use a pty
+// or a plain file.
+//
+// Console output goes through a History, so that the devices are read even
+// when nobody reads the stdio pipes and the latest output stays available,
+// e.g. for a debug dump after a crash.
+//
+// For terminal tasks a pty sits between the stdio pipes and the device, so
+// that the task gets a window size interactive shells can render with.
+type Console struct {
+	cfg ConsoleConfig
+
+	mu          sync.Mutex
+	dev         *os.File
+	pty         console.Console
+	winsize     *console.WinSize
+	stdinPipe   io.Closer
+	stdinClosed bool
+	closers     []io.Closer
+	done        chan struct{}
+
+	// history and logHistory keep the output of the console and log
+	// devices; outReader and errReader forward it to the stdio pipes and
+	// outOff and errOff tell where they resume on the next attach.
+	history    *History
+	logHistory *History
+	outReader  *HistoryReader
+	errReader  *HistoryReader
+	outOff     int64
+	errOff     int64
+
+	// shell is held by the command running in the RTOS shell, see Run.
+	shell chan struct{}
+}
+
+// ConsoleConfig configures a Console.
+type ConsoleConfig struct {
+	// Stdin is the stdin named pipe of the task, not bridged if empty.
+	Stdin string
+	// Stdout and Stderr are where the output goes, usually the stdio of the
+	// task opened once with Output.Open, as they outlive the attaches of the
+	// console. Output to nil writers is discarded.
+	Stdout io.Writer
+	Stderr io.Writer
+	// Terminal bridges the stdio pipes to the device through a pty.
+	Terminal bool
+	// ResizeEscape also forwards window size changes of a terminal to the
+	// RTOS, as an xterm window size escape sequence.
+	ResizeEscape bool
+	// HistorySize is the number of output bytes kept per device, or
+	// DefaultHistorySize if zero.
+	HistorySize int
+}
+
+// NewConsole returns a Console configured with cfg.
+func NewConsole(cfg ConsoleConfig) *Console {
+	return &Console{
+		cfg:        cfg,
+		history:    NewHistory(cfg.HistorySize),
+		logHistory: NewHistory(cfg.HistorySize),
+		shell:      make(chan struct{}, 1),
+	}
+}
+
+// Attach opens the console device at path, and the log device at logPath
+// unless it is empty, and starts bridging them to the stdio pipes until the
+// console device hangs up or c gets closed. A console can be attached again
+// once Done is closed, e.g. after the client was restarted.
+func (c *Console) Attach(ctx context.Context, path, logPath string) (retErr error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done != nil {
+		select {
+		case <-c.done:
+		default:
+			return errors.New("console already attached")
+		}
+	}
+
+	var closers []io.Closer
+	defer func() {
+		if retErr != nil {
+			closeAll(closers)
+		}
+	}()
+
+	dev, err := openDevice(path)
+	if err != nil {
+		return err
+	}
+	closers = append(closers, dev)
+
+	// shell is what the stdio pipes are bridged to
+	var shell io.ReadWriter = dev
+	var pty console.Console
+	if c.cfg.Terminal {
+		var slave *os.File
+		if pty, slave, err = newPty(); err != nil {
+			return err
+		}
+		closers = append(closers, pty, slave)
+
+		if c.winsize != nil {
+			if err := pty.Resize(*c.winsize); err != nil {
+				return fmt.Errorf("setting window size: %w", err)
+			}
+		}
+
+		go func() {
+			b := make([]byte, 4096)
+			if _, err := io.CopyBuffer(dev, slave, b); err != nil && !isClosed(err) {
+				log.WithError(err).Warnf("failed to copy terminal input to console %s", path)
+			}
+		}()
+		go func() {
+			copyOutput(slave, dev, path, "terminal")
+			c.Close()
+		}()
+		shell = pty
+	}
+
+	var errReader *HistoryReader
+	if logPath != "" {
+		logDev, err := openDevice(logPath)
+		if err != nil {
+			return err
+		}
+		closers = append(closers, logDev)
+		go copyOutput(c.logHistory, logDev, logPath, "stderr")
+
+		errReader = c.logHistory.NewReader(c.errOff)
+		closers = append(closers, errReader)
+		go forward(orDiscard(c.cfg.Stderr), errReader, "stderr")
+	}
+
+	if c.cfg.Stdin != "" && !c.stdinClosed {
+		in, err := fifo.OpenFifo(ctx, c.cfg.Stdin, syscall.O_RDONLY|syscall.O_NONBLOCK, 0)
+		if err != nil {
+			return fmt.Errorf("opening read only fifo %s: %w", c.cfg.Stdin, err)
+		}
+		closers = append(closers, in)
+		c.stdinPipe = in
+
+		go func() {
+			b := make([]byte, 4096)
+			if _, err := io.CopyBuffer(shell, in, b); err != nil && !isClosed(err) {
+				log.WithError(err).Warnf("failed to copy stdin to console %s", path)
+			}
+		}()
+	}
+
+	outReader := c.history.NewReader(c.outOff)
+	closers = append(closers, outReader)
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		forward(orDiscard(c.cfg.Stdout), outReader, "stdout")
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		copyOutput(c.history, shell, path, "stdout")
+
+		// the last words of a crashing RTOS matter most, but not enough to
+		// wait for a stalled stdout pipe
+		outReader.Drain()
+		select {
+		case <-forwarded:
+		case <-time.After(drainTimeout):
+		}
+		c.Close()
+	}()
+
+	c.dev, c.pty, c.closers, c.done = dev, pty, closers, done
+	c.outReader, c.errReader = outReader, errReader
+	return nil
+}
+
+// Resize sets the window size of a terminal console, now if it is attached
+// and on every later attach.
+func (c *Console) Resize(width, height uint32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.cfg.Terminal {
+		return errors.New("console is not a terminal")
+	}
+
+	c.winsize = &console.WinSize{Width: uint16(width), Height: uint16(height)}
+	if c.pty == nil {
+		return nil
+	}
+	if err := c.pty.Resize(*c.winsize); err != nil {
+		return fmt.Errorf("setting window size: %w", err)
+	}
+
+	if c.cfg.ResizeEscape {
+		esc := fmt.Sprintf("\x1b[8;%d;%dt", height, width)
+		if _, err := c.dev.WriteString(esc); err != nil {
+			return fmt.Errorf("forwarding window size to console: %w", err)
+		}
+	}
+	return nil
+}
+
+// CloseStdin stops copying the stdin pipe into the console and sends an
+// end-of-transmission character instead, which is how an RTOS shell sees
+// the end of its input.
+func (c *Console) CloseStdin() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stdinClosed {
+		return nil
+	}
+	c.stdinClosed = true
+
+	var errs []error
+	if c.stdinPipe != nil {
+		if err := c.stdinPipe.Close(); err != nil && !isClosed(err) {
+			errs = append(errs, fmt.Errorf("closing stdin: %w", err))
+		}
+		c.stdinPipe = nil
+	}
+	if c.dev != nil {
+		if _, err := c.dev.Write([]byte{eot}); err != nil && !isClosed(err) {
+			errs = append(errs, fmt.Errorf("sending end of input to console: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Done returns a channel closed once the console is detached.
+func (c *Console) Done() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done
+}
+
+// Close detaches the console, closing the devices and the stdin pipe. The
+// stdout and stderr of the config are left to the caller.
+func (c *Console) Close() error {
+	c.mu.Lock()
+	closers := c.closers
+	if c.outReader != nil {
+		c.outOff = c.outReader.Offset()
+	}
+	if c.errReader != nil {
+		c.errOff = c.errReader.Offset()
+	}
+	c.dev, c.pty, c.stdinPipe, c.closers = nil, nil, nil, nil
+	c.outReader, c.errReader = nil, nil
+	c.mu.Unlock()
+
+	// closing can block, e.g. on a stalled pipe, so not under c.mu
+	return closeAll(closers)
+}
+
+// Log writes the output of the console and log devices into l, from the
+// oldest retained output on.
+func (c *Console) Log(l *LogFile) {
+	l.Follow("stdout", c.history.NewReader(0))
+	l.Follow("stderr", c.logHistory.NewReader(0))
+}
+
+// History returns the retained output of the console device and of the log
+// device.
+func (c *Console) History() (stdout, stderr []byte) {
+	return c.history.Bytes(), c.logHistory.Bytes()
+}
+
+// NewReader returns a reader of the output of the console device, from the
+// output written next on.
+func (c *Console) NewReader() *HistoryReader {
+	return c.history.NewReader(c.history.Offset())
+}
+
+// Written returns how many bytes were read from the console device and from
+// the log device.
+func (c *Console) Written() (stdout, stderr int64) {
+	return c.history.Offset(), c.logHistory.Offset()
+}
+
+// openDevice opens a console device for reading and writing, in raw mode as
+// the RTOS shell does its own echo and line editing.
+func openDevice(path string) (*os.File, error) {
+	dev, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("opening console device %s: %w", path, err)
+	}
+	if err := setRaw(dev); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("setting console device %s raw: %w", path, err)
+	}
+	return dev, nil
+}
+
+// copyOutput copies the output of the console device at path, read from r,
+// until it hangs up or gets closed.
+func copyOutput(w io.Writer, r io.Reader, path, stream string) {
+	b := make([]byte, 4096)
+	if _, err := io.CopyBuffer(w, r, b); err != nil && !isClosed(err) {
+		log.WithError(err).Warnf("failed to copy console %s to %s", path, stream)
+	}
+}
+
+// orDiscard returns w, or a writer discarding the output if w is nil.
+func orDiscard(w io.Writer) io.Writer {
+	if w == nil {
+		return io.Discard
+	}
+	return w
+}
+
+// forward copies console output from a history into a stdio pipe until the
+// reader is closed or drained.
+func forward(w io.Writer, r *HistoryReader, stream string) {
+	b := make([]byte, 4096)
+	if _, err := io.CopyBuffer(w, r, b); err != nil && !isClosed(err) {
+		log.WithError(err).Warnf("failed to forward console output to %s", stream)
+	}
+}
+
+// openFifoWriter opens the named pipe at path for writing. The returned
+// reader needs to remain open to avoid "broken pipe" in detached mode.
+func openFifoWriter(ctx context.Context, path string) (w io.WriteCloser, r io.Closer, _ error) {
+	ok, err := fifo.IsFifo(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("checking whether file %s is a fifo: %w", path, err)
+	}
+	if !ok {
+		return nil, nil, fmt.Errorf("file %s is not a fifo", path)
+	}
+
+	if w, err = fifo.OpenFifo(ctx, path, syscall.O_WRONLY, 0); err != nil {
+		return nil, nil, fmt.Errorf("opening write only fifo %s: %w", path, err)
+	}
+	if r, err = fifo.OpenFifo(ctx, path, syscall.O_RDONLY, 0); err != nil {
+		w.Close()
+		return nil, nil, fmt.Errorf("opening read only fifo %s: %w", path, err)
+	}
+	return w, r, nil
+}
+
+// closeAll closes all closers, in reverse order.
+func closeAll(closers []io.Closer) error {
+	var errs []error
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i].Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// isClosed tells whether err comes from a closed file or a hung up terminal,
+// which both end bridging.
+func isClosed(err error) bool {
+	return errors.Is(err, os.ErrClosed) || errors.Is(err, syscall.EIO)
+}
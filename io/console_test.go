@@ -0,0 +1,181 @@
+package io
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/containerd/console"
+	"github.com/containerd/fifo"
+)
+
+// openClientFifo opens the containerd end of a stdio fifo, which containerd
+// creates and opens before the task.
+func openClientFifo(ctx context.Context, t *testing.T, path string, flag int) io.ReadWriteCloser {
+	t.Helper()
+	f, err := fifo.OpenFifo(ctx, path, flag|syscall.O_CREAT|syscall.O_NONBLOCK, 0o700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestConsoleAttach(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	stdin, stdout, stderr := filepath.Join(dir, "stdin"), filepath.Join(dir, "stdout"), filepath.Join(dir, "stderr")
+
+	out := openClientFifo(ctx, t, stdout, syscall.O_RDONLY)
+	errOut := openClientFifo(ctx, t, stderr, syscall.O_RDONLY)
+	in := openClientFifo(ctx, t, stdin, syscall.O_WRONLY)
+
+	// pty slaves stand in for the RPMsg TTYs of the shell and log channels
+	rtos, dev, err := console.NewPty()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rtos.Close()
+	rtosLog, logDev, err := console.NewPty()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rtosLog.Close()
+
+	w, errW, closer, err := Output{Stdout: stdout, Stderr: stderr}.Open(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	c := NewConsole(ConsoleConfig{Stdin: stdin, Stdout: w, Stderr: errW})
+	if err := c.Attach(ctx, dev, logDev); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := rtos.Write([]byte("uart:~$ \n")); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil || line != "uart:~$ \n" {
+		t.Errorf("expected console output on stdout, got %q: %v", line, err)
+	}
+
+	if _, err := rtosLog.Write([]byte("<inf> main: booted\n")); err != nil {
+		t.Fatal(err)
+	}
+	line, err = bufio.NewReader(errOut).ReadString('\n')
+	if err != nil || line != "<inf> main: booted\n" {
+		t.Errorf("expected log channel output on stderr, got %q: %v", line, err)
+	}
+
+	if _, err := in.Write([]byte("kernel version\n")); err != nil {
+		t.Fatal(err)
+	}
+	rtosIn := bufio.NewReader(rtos)
+	line, err = rtosIn.ReadString('\n')
+	if err != nil || line != "kernel version\n" {
+		t.Errorf("expected stdin on the console, got %q: %v", line, err)
+	}
+
+	if stdout, stderr := c.History(); string(stdout) != "uart:~$ \n" || string(stderr) != "<inf> main: booted\n" {
+		t.Errorf("expected console history, got %q and %q", stdout, stderr)
+	}
+
+	if err := c.CloseStdin(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := rtosIn.ReadByte(); err != nil || b != eot {
+		t.Errorf("expected end of transmission on the console, got %q: %v", b, err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Errorf("unexpected error closing console: %v", err)
+	}
+	select {
+	case <-c.Done():
+	case <-ctx.Done():
+		t.Errorf("console still attached after close")
+	}
+}
+
+func TestConsoleTerminal(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	stdin, stdout := filepath.Join(dir, "stdin"), filepath.Join(dir, "stdout")
+	out := openClientFifo(ctx, t, stdout, syscall.O_RDONLY)
+	in := openClientFifo(ctx, t, stdin, syscall.O_WRONLY)
+
+	rtos, dev, err := console.NewPty()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rtos.Close()
+
+	w, _, closer, err := Output{Stdout: stdout}.Open(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	c := NewConsole(ConsoleConfig{Stdin: stdin, Stdout: w, Terminal: true, ResizeEscape: true})
+	// resizing before attach applies once attached
+	if err := c.Resize(100, 30); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Attach(ctx, dev, ""); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	winsize := func() console.WinSize {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		ws, err := c.pty.Size()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ws
+	}
+	if ws := winsize(); ws.Width != 100 || ws.Height != 30 {
+		t.Errorf("expected a 100x30 terminal, got %dx%d", ws.Width, ws.Height)
+	}
+
+	if err := c.Resize(80, 24); err != nil {
+		t.Fatal(err)
+	}
+	if ws := winsize(); ws.Width != 80 || ws.Height != 24 {
+		t.Errorf("expected a 80x24 terminal, got %dx%d", ws.Width, ws.Height)
+	}
+
+	rtosIn := bufio.NewReader(rtos)
+	esc := make([]byte, len("\x1b[8;24;80t"))
+	if _, err := io.ReadFull(rtosIn, esc); err != nil || string(esc) != "\x1b[8;24;80t" {
+		t.Errorf("expected the window size escape on the console, got %q: %v", esc, err)
+	}
+
+	if _, err := rtos.Write([]byte("uart:~$ \n")); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil || line != "uart:~$ \n" {
+		t.Errorf("expected console output on stdout, got %q: %v", line, err)
+	}
+
+	if _, err := in.Write([]byte("help\n")); err != nil {
+		t.Fatal(err)
+	}
+	line, err = rtosIn.ReadString('\n')
+	if err != nil || line != "help\n" {
+		t.Errorf("expected stdin on the console, got %q: %v", line, err)
+	}
+}
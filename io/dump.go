@@ -0,0 +1,89 @@
+package io
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "mica-shim/logger"
+)
+
+// dumpTimeout bounds a single console history dump.
+const dumpTimeout = 10 * time.Second
+
+// ServeHistory serves the console history of c on a unix socket at path,
+// until the returned listener is closed. A client sends the name of a
+// stream, stdout or stderr, on a line and gets its history back.
+func ServeHistory(path string, c *Console) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("creating console history socket directory: %w", err)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("removing stale console history socket: %w", err)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listening on console history socket: %w", err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				if err := serveDump(conn, c); err != nil {
+					log.WithError(err).Warn("failed to dump console history")
+				}
+			}()
+		}
+	}()
+	return l, nil
+}
+
+// serveDump answers a single console history request on conn.
+func serveDump(conn net.Conn, c *Console) error {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dumpTimeout))
+
+	stream, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("reading stream name: %w", err)
+	}
+
+	stdout, stderr := c.History()
+	switch strings.TrimSpace(stream) {
+	case "stdout":
+		_, err = conn.Write(stdout)
+	case "stderr":
+		_, err = conn.Write(stderr)
+	default:
+		return fmt.Errorf("unknown stream %q", strings.TrimSpace(stream))
+	}
+	return err
+}
+
+// DumpHistory writes the history of stream, stdout or stderr, of the console
+// served at path to w.
+func DumpHistory(w io.Writer, path, stream string) error {
+	conn, err := net.DialTimeout("unix", path, dumpTimeout)
+	if err != nil {
+		return fmt.Errorf("connecting to console history socket: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dumpTimeout))
+
+	if _, err := fmt.Fprintln(conn, stream); err != nil {
+		return fmt.Errorf("requesting %s history: %w", stream, err)
+	}
+	if _, err := io.Copy(w, conn); err != nil {
+		return fmt.Errorf("reading %s history: %w", stream, err)
+	}
+	return nil
+}
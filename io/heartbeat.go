@@ -0,0 +1,85 @@
+package io
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+)
+
+// ErrHeartbeatMissed is returned by Heartbeat.Watch when the RTOS missed its
+// heartbeat.
+var ErrHeartbeatMissed = errors.New("heartbeat missed")
+
+// Heartbeat describes the periodic heartbeat of an RTOS.
+type Heartbeat struct {
+	// Pattern matches the heartbeat lines in a console stream. Without it,
+	// any message counts as a heartbeat, as on a dedicated RPMsg endpoint.
+	Pattern *regexp.Regexp
+	// Timeout is how long the RTOS may go without a heartbeat.
+	Timeout time.Duration
+}
+
+// Watch reads heartbeats from r until ctx is done, and returns an
+// ErrHeartbeatMissed error once Timeout elapsed without one, counting from
+// when Watch was called. r should be closed once Watch returned, to stop
+// reading it.
+func (h Heartbeat) Watch(ctx context.Context, r io.Reader) error {
+	beats := make(chan struct{}, 1)
+	go h.read(r, beats)
+
+	timer := time.NewTimer(h.Timeout)
+	defer timer.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-beats:
+			last = time.Now()
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(h.Timeout)
+		case <-timer.C:
+			return fmt.Errorf("no heartbeat since %s: %w", last.Format(time.RFC3339), ErrHeartbeatMissed)
+		}
+	}
+}
+
+// read sends a beat for every heartbeat read from r, until reading fails.
+func (h Heartbeat) read(r io.Reader, beats chan<- struct{}) {
+	beat := func() {
+		select {
+		case beats <- struct{}{}:
+		default:
+		}
+	}
+
+	if h.Pattern == nil {
+		b := make([]byte, 4096)
+		for {
+			n, err := r.Read(b)
+			if n > 0 {
+				beat()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	br := bufio.NewReaderSize(r, maxLineSize)
+	for {
+		line, err := br.ReadSlice('\n')
+		if h.Pattern.Match(line) {
+			beat()
+		}
+		if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+			return
+		}
+	}
+}
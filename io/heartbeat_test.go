@@ -0,0 +1,68 @@
+package io
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestHeartbeat(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	h := Heartbeat{Pattern: regexp.MustCompile(`^<hb> \d+`), Timeout: 200 * time.Millisecond}
+	r, w := io.Pipe()
+	defer r.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- h.Watch(ctx, r) }()
+
+	// heartbeats keep the watchdog happy, other output does not
+	for i := 0; i < 5; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if _, err := io.WriteString(w, "<hb> 42\r\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("expected the heartbeat to be seen, got %v", err)
+	default:
+	}
+
+	start := time.Now()
+	go func() {
+		for {
+			if _, err := io.WriteString(w, "uart:~$ kernel uptime\r\n"); err != nil {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+	if err := <-done; !errors.Is(err, ErrHeartbeatMissed) {
+		t.Errorf("expected a missed heartbeat, got %v", err)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("heartbeat missed too early, after %s", d)
+	}
+
+	// without a pattern, any message is a heartbeat
+	h.Pattern = nil
+	r2, w2 := io.Pipe()
+	defer r2.Close()
+	watchCtx, watchCancel := context.WithCancel(ctx)
+	go func() { done <- h.Watch(watchCtx, r2) }()
+	for i := 0; i < 3; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if _, err := w2.Write([]byte{0}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	watchCancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the watch to be canceled, got %v", err)
+	}
+}
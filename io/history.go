@@ -0,0 +1,172 @@
+package io
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// DefaultHistorySize is the number of console output bytes a History keeps
+// unless configured otherwise.
+const DefaultHistorySize = 64 << 10
+
+// History is a bounded ring buffer of console output. Writes never block:
+// once the buffer is full the oldest output is dropped, so that an RTOS
+// console is read at its own pace whether anyone consumes its output or not.
+// Readers get the retained output first and then follow new output.
+type History struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	buf     []byte
+	written int64
+	closed  bool
+}
+
+// NewHistory returns a History keeping the last size bytes written.
+func NewHistory(size int) *History {
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+	h := &History{buf: make([]byte, size)}
+	h.cond = sync.NewCond(&h.mu)
+	return h
+}
+
+// Write appends p to h, dropping the oldest output that does not fit.
+func (h *History) Write(p []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return 0, os.ErrClosed
+	}
+
+	n := len(p)
+	if n > len(h.buf) {
+		h.written += int64(n - len(h.buf))
+		p = p[n-len(h.buf):]
+	}
+	for len(p) > 0 {
+		i := int(h.written % int64(len(h.buf)))
+		c := copy(h.buf[i:], p)
+		h.written += int64(c)
+		p = p[c:]
+	}
+	h.cond.Broadcast()
+	return n, nil
+}
+
+// Bytes returns a copy of the retained output.
+func (h *History) Bytes() []byte {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	b, _ := h.read(h.start(), nil)
+	return b
+}
+
+// Close closes h; readers get io.EOF once they caught up.
+func (h *History) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	h.cond.Broadcast()
+	return nil
+}
+
+// Offset returns the offset of the next output written to h.
+func (h *History) Offset() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.written
+}
+
+// NewReader returns a reader of h starting at the output written at offset
+// off, or at the oldest retained output if that was dropped already. An
+// offset of 0 replays all retained output.
+func (h *History) NewReader(off int64) *HistoryReader {
+	return &HistoryReader{h: h, off: off}
+}
+
+// start returns the offset of the oldest retained output.
+func (h *History) start() int64 {
+	if h.written > int64(len(h.buf)) {
+		return h.written - int64(len(h.buf))
+	}
+	return 0
+}
+
+// read copies the retained output from offset off into p, or into a new
+// slice if p is nil, and returns it with the offset following it.
+func (h *History) read(off int64, p []byte) ([]byte, int64) {
+	n := h.written - off
+	if p == nil {
+		p = make([]byte, n)
+	} else if int64(len(p)) < n {
+		n = int64(len(p))
+	}
+	p = p[:n]
+	for c := 0; c < len(p); {
+		i := int((off + int64(c)) % int64(len(h.buf)))
+		c += copy(p[c:], h.buf[i:])
+	}
+	return p, off + n
+}
+
+// HistoryReader reads the output of a History as it is written. Output a
+// reader falls behind on by more than the size of the history is skipped.
+type HistoryReader struct {
+	h      *History
+	off    int64
+	closed bool
+	drain  bool
+}
+
+// Read reads retained output, waiting for more if the reader caught up.
+func (r *HistoryReader) Read(p []byte) (int, error) {
+	h := r.h
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for r.off >= h.written && !h.closed && !r.closed && !r.drain {
+		h.cond.Wait()
+	}
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+	if r.off >= h.written {
+		return 0, io.EOF
+	}
+	if start := h.start(); r.off < start {
+		r.off = start
+	}
+
+	b, off := h.read(r.off, p)
+	r.off = off
+	return len(b), nil
+}
+
+// Offset returns the offset of the next output r reads, e.g. to resume
+// reading with a new reader.
+func (r *HistoryReader) Offset() int64 {
+	r.h.mu.Lock()
+	defer r.h.mu.Unlock()
+	return r.off
+}
+
+// Drain makes r return io.EOF once it caught up, instead of waiting for more
+// output.
+func (r *HistoryReader) Drain() {
+	r.h.mu.Lock()
+	defer r.h.mu.Unlock()
+	r.drain = true
+	r.h.cond.Broadcast()
+}
+
+// Close makes pending and future reads of r fail.
+func (r *HistoryReader) Close() error {
+	r.h.mu.Lock()
+	defer r.h.mu.Unlock()
+	r.closed = true
+	r.h.cond.Broadcast()
+	return nil
+}
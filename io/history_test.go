@@ -0,0 +1,84 @@
+package io
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	h := NewHistory(8)
+
+	// a reader keeps up with what is written
+	r := h.NewReader(0)
+	io.WriteString(h, "boot\n")
+	b := make([]byte, 16)
+	if n, err := r.Read(b); err != nil || string(b[:n]) != "boot\n" {
+		t.Errorf("expected to read %q, got %q: %v", "boot\n", b[:n], err)
+	}
+
+	// the oldest output is dropped once the history is full
+	io.WriteString(h, "uart:~$ ")
+	if got := h.Bytes(); string(got) != "uart:~$ " {
+		t.Errorf("expected history %q, got %q", "uart:~$ ", got)
+	}
+	io.WriteString(h, "0123456789")
+	if got := h.Bytes(); string(got) != "23456789" {
+		t.Errorf("expected history %q, got %q", "23456789", got)
+	}
+
+	// a reader that fell behind skips what was dropped
+	if n, err := r.Read(b); err != nil || string(b[:n]) != "23456789" {
+		t.Errorf("expected to read %q, got %q: %v", "23456789", b[:n], err)
+	}
+
+	// a late reader gets the retained output replayed
+	late := h.NewReader(0)
+	if got, err := io.ReadAll(io.LimitReader(late, 8)); err != nil || string(got) != "23456789" {
+		t.Errorf("expected replay %q, got %q: %v", "23456789", got, err)
+	}
+
+	late.Drain()
+	if n, err := late.Read(b); n != 0 || err != io.EOF {
+		t.Errorf("expected EOF from a drained reader, got %d, %v", n, err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := r.Read(b)
+		done <- err
+	}()
+	r.Close()
+	if err := <-done; !errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected a pending read to fail on close, got %v", err)
+	}
+}
+
+func TestServeHistory(t *testing.T) {
+	c := NewConsole(ConsoleConfig{HistorySize: 64})
+	io.WriteString(c.history, "*** Booting Zephyr OS ***\n")
+	io.WriteString(c.logHistory, "<err> os: fatal error\n")
+
+	path := filepath.Join(t.TempDir(), "console", "history.sock")
+	l, err := ServeHistory(path, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for stream, want := range map[string]string{
+		"stdout": "*** Booting Zephyr OS ***\n",
+		"stderr": "<err> os: fatal error\n",
+	} {
+		var buf bytes.Buffer
+		if err := DumpHistory(&buf, path, stream); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != want {
+			t.Errorf("expected %s history %q, got %q", stream, want, buf.String())
+		}
+	}
+}
@@ -6,21 +6,20 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"syscall"
-
-	"github.com/containerd/fifo"
 )
 
 // PipeIO can copy data from an anonymous pipe p into a named pipe dst.
 // The anonymous pipe is meant to be connected to a container's stdout stream,
-// whereas the named pipe is managed by containerd.
+// whereas the named pipe is managed by containerd. dst can also be a
+// binary:// or file:// URI, see Output.
 type PipeIO struct {
 	p   *pipe
-	dst string
+	out Output
 }
 
-// NewPipeIO creates an anonymous pipe for copying data into the dst named pipe.
-func NewPipeIO(dst string) (*PipeIO, error) {
+// NewPipeIO creates an anonymous pipe for copying data into the dst named pipe
+// of task id in namespace ns.
+func NewPipeIO(id, ns, dst string) (*PipeIO, error) {
 	p, err := newPipe()
 	if err != nil {
 		return nil, fmt.Errorf("creating pipe: %w", err)
@@ -28,38 +27,22 @@ func NewPipeIO(dst string) (*PipeIO, error) {
 
 	return &PipeIO{
 		p:   p,
-		dst: dst,
+		out: Output{ID: id, Namespace: ns, Stdout: dst},
 	}, nil
 }
 
 // Copy continuously copies data from pio's anonymous pipe (read end) to its
 // dst pipe, until any of them gets closed.
 func (pio *PipeIO) Copy(ctx context.Context) error {
-	ok, err := fifo.IsFifo(pio.dst)
+	var closers []io.Closer
+	defer func() { closeAll(closers) }()
+	w, _, err := pio.out.open(ctx, &closers)
 	if err != nil {
-		return fmt.Errorf("checking whether file %s is a fifo: %w", pio.dst, err)
-	}
-	if !ok {
-		return fmt.Errorf("file %s is not a fifo", pio.dst)
-	}
-
-	var fw io.WriteCloser
-	var fr io.Closer
-
-	if fw, err = fifo.OpenFifo(ctx, pio.dst, syscall.O_WRONLY, 0); err != nil {
-		return fmt.Errorf("opening write only fifo %s: %w", pio.dst, err)
-	}
-	defer fw.Close()
-
-	// the read end of the dst pipe needs to remain open to avoid "broken pipe"
-	// in detached mode
-	if fr, err = fifo.OpenFifo(ctx, pio.dst, syscall.O_RDONLY, 0); err != nil {
-		return fmt.Errorf("opening read only fifo %s: %w", pio.dst, err)
+		return err
 	}
-	defer fr.Close()
 
 	b := make([]byte, 4096)
-	if _, err := io.CopyBuffer(fw, pio.p.r, b); err != nil {
+	if _, err := io.CopyBuffer(w, pio.p.r, b); err != nil {
 		return fmt.Errorf("copying pipe data to destination: %w", err)
 	}
 
@@ -0,0 +1,161 @@
+package io
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "mica-shim/logger"
+)
+
+// maxLineSize is the longest console line a LogFile writes as one; longer
+// lines are split.
+const maxLineSize = 16 << 10
+
+// LogFile writes console output into a file line by line, each line prefixed
+// with the host time it was read at and the stream it came from:
+//
+//	2006-01-02T15:04:05.999999999Z07:00 stdout *** Booting Zephyr OS ***
+//
+// Once the file would outgrow its maximum size it is rotated to path.1,
+// path.1 to path.2 and so on, keeping at most the configured number of files.
+type LogFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+
+	wg      sync.WaitGroup
+	readers []*HistoryReader
+}
+
+// NewLogFile opens the log file at path for appending, creating it and its
+// directory if needed. maxFiles counts the rotated files and the current one.
+func NewLogFile(path string, maxSize int64, maxFiles int) (*LogFile, error) {
+	if maxSize <= 0 || maxFiles <= 0 {
+		return nil, fmt.Errorf("invalid log file limits: %d bytes, %d files", maxSize, maxFiles)
+	}
+
+	f, err := openLogFile(path)
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("checking log file %s: %w", path, err)
+	}
+
+	return &LogFile{path: path, maxSize: maxSize, maxFiles: maxFiles, f: f, size: st.Size()}, nil
+}
+
+// Follow logs the output read from r as stream, until r is drained or
+// closed.
+func (l *LogFile) Follow(stream string, r *HistoryReader) {
+	l.mu.Lock()
+	l.readers = append(l.readers, r)
+	l.mu.Unlock()
+
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+
+		br := bufio.NewReaderSize(r, maxLineSize)
+		for {
+			line, err := br.ReadSlice('\n')
+			if len(line) > 0 {
+				if werr := l.WriteLine(stream, line); werr != nil {
+					log.WithError(werr).Warnf("failed to write console %s to %s", stream, l.path)
+				}
+			}
+			if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+				return
+			}
+		}
+	}()
+}
+
+// WriteLine writes a single line of stream, without its line ending.
+func (l *LogFile) WriteLine(stream string, line []byte) error {
+	line = bytes.TrimRight(line, "\r\n")
+
+	var b bytes.Buffer
+	b.WriteString(time.Now().Format(time.RFC3339Nano))
+	b.WriteByte(' ')
+	b.WriteString(stream)
+	b.WriteByte(' ')
+	b.Write(line)
+	b.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return os.ErrClosed
+	}
+	if l.size > 0 && l.size+int64(b.Len()) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.f.Write(b.Bytes())
+	l.size += int64(n)
+	return err
+}
+
+// Close logs what the followed readers have left to read and closes l.
+func (l *LogFile) Close() error {
+	l.mu.Lock()
+	for _, r := range l.readers {
+		r.Drain()
+	}
+	l.mu.Unlock()
+	l.wg.Wait()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// rotate shifts the rotated files by one, dropping the oldest, and starts
+// a new file.
+func (l *LogFile) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return fmt.Errorf("closing log file %s: %w", l.path, err)
+	}
+	l.f = nil
+
+	for i := l.maxFiles - 1; i > 0; i-- {
+		src := l.path
+		if i > 1 {
+			src = fmt.Sprintf("%s.%d", l.path, i-1)
+		}
+		if err := os.Rename(src, fmt.Sprintf("%s.%d", l.path, i)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("rotating log file %s: %w", src, err)
+		}
+	}
+	if l.maxFiles == 1 {
+		if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing log file %s: %w", l.path, err)
+		}
+	}
+
+	f, err := openLogFile(l.path)
+	if err != nil {
+		return err
+	}
+	l.f, l.size = f, 0
+	return nil
+}
@@ -0,0 +1,61 @@
+package io
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "default", "zephyr.log")
+	l, err := NewLogFile(path, 128, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, errOut := NewHistory(0), NewHistory(0)
+	l.Follow("stdout", out.NewReader(0))
+	l.Follow("stderr", errOut.NewReader(0))
+	io.WriteString(out, "*** Booting Zephyr OS ***\r\nuart:~$ ")
+	io.WriteString(errOut, "<err> os: fatal error\n")
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// three lines do not fit in a single file
+	line := regexp.MustCompile(`^\d{4}-\d\d-\d\dT\S+ (stdout|stderr) (.*)$`)
+	var got []string
+	for _, p := range []string{path + ".1", path} {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+			m := line.FindStringSubmatch(l)
+			if m == nil {
+				t.Fatalf("unexpected log line %q in %s", l, p)
+			}
+			got = append(got, m[1]+" "+m[2])
+		}
+	}
+	if len(got) != 3 || !contains(got, "stdout *** Booting Zephyr OS ***") ||
+		!contains(got, "stdout uart:~$ ") || !contains(got, "stderr <err> os: fatal error") {
+		t.Errorf("unexpected log lines %q", got)
+	}
+
+	if _, err := os.Stat(path + ".2"); !os.IsNotExist(err) {
+		t.Errorf("expected at most 2 log files, got %v", err)
+	}
+}
+
+func contains(lines []string, line string) bool {
+	for _, l := range lines {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
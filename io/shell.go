@@ -0,0 +1,157 @@
+package io
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// etx is the end-of-text character (^C), which interrupts the command an
+// RTOS shell runs.
+const etx = 0x03
+
+// DefaultPrompt matches the default prompt of the Zephyr shell.
+var DefaultPrompt = regexp.MustCompile(`uart:~\$ $`)
+
+// ErrNotAttached is returned when running a command on a console that is not
+// attached to its device.
+var ErrNotAttached = errors.New("console not attached")
+
+// notFound matches the shell's answer to an unknown command.
+var notFound = regexp.MustCompile(`(?m)command not found\s*$`)
+
+// firstInt matches the first integer of a status command output.
+var firstInt = regexp.MustCompile(`-?\d+`)
+
+// Shell describes the shell an RTOS runs on its console.
+type Shell struct {
+	// Prompt matches the end of the output of a command, DefaultPrompt if
+	// nil.
+	Prompt *regexp.Regexp
+	// StatusCommand prints the return value of the previous command, such
+	// as retval on Zephyr. Without it the exit status of a command is 127
+	// if the shell does not know it and 0 otherwise.
+	StatusCommand string
+}
+
+// Run runs the command line args in the RTOS shell on c and returns its
+// output, without the echoed command line and the prompt, and its exit
+// status. Commands run one at a time, and a command is interrupted with ^C
+// if ctx is done before the shell prompts again.
+func (c *Console) Run(ctx context.Context, sh Shell, args []string) ([]byte, int, error) {
+	select {
+	case c.shell <- struct{}{}:
+		defer func() { <-c.shell }()
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+
+	if sh.Prompt == nil {
+		sh.Prompt = DefaultPrompt
+	}
+
+	cmdline := shellQuote(args)
+	out, err := c.runLine(ctx, sh.Prompt, cmdline)
+	if err != nil {
+		return out, 0, err
+	}
+
+	if sh.StatusCommand == "" {
+		if notFound.Match(out) {
+			return out, 127, nil
+		}
+		return out, 0, nil
+	}
+
+	st, err := c.runLine(ctx, sh.Prompt, sh.StatusCommand)
+	if err != nil {
+		return out, 0, fmt.Errorf("getting exit status: %w", err)
+	}
+	status, err := strconv.Atoi(firstInt.FindString(string(st)))
+	if err != nil {
+		return out, 0, fmt.Errorf("parsing exit status %q: %w", st, err)
+	}
+	return out, status, nil
+}
+
+// runLine writes a command line to the console device and collects the
+// output following it until the shell prompts again.
+func (c *Console) runLine(ctx context.Context, prompt *regexp.Regexp, cmdline string) ([]byte, error) {
+	c.mu.Lock()
+	dev := c.dev
+	c.mu.Unlock()
+	if dev == nil {
+		return nil, ErrNotAttached
+	}
+
+	r := c.history.NewReader(c.history.Offset())
+	defer r.Close()
+
+	if _, err := dev.WriteString(cmdline + "\n"); err != nil {
+		return nil, fmt.Errorf("writing command line to console: %w", err)
+	}
+
+	type result struct {
+		out []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var out []byte
+		b := make([]byte, 4096)
+		for {
+			n, err := r.Read(b)
+			out = append(out, b[:n]...)
+			if loc := prompt.FindIndex(out); loc != nil {
+				done <- result{out: out[:loc[0]]}
+				return
+			}
+			if err != nil {
+				done <- result{out: out, err: err}
+				return
+			}
+		}
+	}()
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			return cleanOutput(res.out, cmdline), fmt.Errorf("reading command output: %w", res.err)
+		}
+		return cleanOutput(res.out, cmdline), nil
+	case <-ctx.Done():
+		r.Close()
+		res := <-done
+		if _, err := dev.Write([]byte{etx}); err != nil && !isClosed(err) {
+			return nil, fmt.Errorf("interrupting command: %w", err)
+		}
+		return cleanOutput(res.out, cmdline), ctx.Err()
+	}
+}
+
+// cleanOutput drops the echoed command line from the output of a command
+// and turns its CRLF line endings into LF.
+func cleanOutput(out []byte, cmdline string) []byte {
+	out = bytes.ReplaceAll(out, []byte("\r\n"), []byte("\n"))
+	if line, rest, ok := bytes.Cut(out, []byte("\n")); ok && strings.TrimSpace(string(line)) == cmdline {
+		out = rest
+	}
+	return out
+}
+
+// shellQuote joins args into a command line, double quoting the arguments
+// the shell would split or unquote.
+func shellQuote(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\"'\\") {
+			arg = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,85 @@
+package io
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containerd/console"
+)
+
+// fakeShell answers the command lines written to a fake RTOS console like
+// the Zephyr shell does, with an echo, the output and a prompt.
+func fakeShell(t *testing.T, rtos console.Console, outputs map[string]string) {
+	in := bufio.NewReader(rtos)
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmdline := strings.TrimSpace(line)
+		out, ok := outputs[cmdline]
+		if !ok {
+			out = strings.Fields(cmdline)[0] + ": command not found\r\n"
+		}
+		if out == "hang" {
+			continue
+		}
+		if _, err := rtos.Write([]byte(cmdline + "\r\n" + out + "uart:~$ ")); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+}
+
+func TestConsoleRun(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rtos, dev, err := console.NewPty()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rtos.Close()
+
+	c := NewConsole(ConsoleConfig{})
+	if _, _, err := c.Run(ctx, Shell{}, []string{"kernel", "version"}); !errors.Is(err, ErrNotAttached) {
+		t.Errorf("expected running on a detached console to fail, got %v", err)
+	}
+
+	if err := c.Attach(ctx, dev, ""); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	go fakeShell(t, rtos, map[string]string{
+		"kernel version":     "Zephyr version 3.6.0\r\n",
+		`echo "hello world"`: "hello world\r\n",
+		"retval":             "-22\r\n",
+		"sleep":              "hang",
+	})
+
+	out, status, err := c.Run(ctx, Shell{}, []string{"kernel", "version"})
+	if err != nil || string(out) != "Zephyr version 3.6.0\n" || status != 0 {
+		t.Errorf("unexpected output %q and status %d: %v", out, status, err)
+	}
+
+	out, status, err = c.Run(ctx, Shell{}, []string{"reboot"})
+	if err != nil || string(out) != "reboot: command not found\n" || status != 127 {
+		t.Errorf("unexpected output %q and status %d: %v", out, status, err)
+	}
+
+	out, status, err = c.Run(ctx, Shell{StatusCommand: "retval"}, []string{"echo", "hello world"})
+	if err != nil || string(out) != "hello world\n" || status != -22 {
+		t.Errorf("unexpected output %q and status %d: %v", out, status, err)
+	}
+
+	runCtx, runCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer runCancel()
+	if _, _, err := c.Run(runCtx, Shell{}, []string{"sleep"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the command to time out, got %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package io
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/containerd/console"
+	"golang.org/x/sys/unix"
+)
+
+// The helpers below work on the raw file descriptors through SyscallConn.
+// Unlike (*os.File).Fd, that leaves the files non-blocking, so that closing
+// them interrupts pending reads.
+
+// control runs fn on the file descriptor of f.
+func control(f *os.File, fn func(fd int) error) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var fnErr error
+	if err := rc.Control(func(fd uintptr) {
+		fnErr = fn(int(fd))
+	}); err != nil {
+		return err
+	}
+	return fnErr
+}
+
+// setRaw puts f in raw mode if it is a terminal.
+func setRaw(f *os.File) error {
+	return control(f, func(fd int) error {
+		t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+		if err != nil {
+			// not a terminal
+			return nil
+		}
+		t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+		t.Oflag &^= unix.OPOST
+		t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		t.Cflag &^= unix.CSIZE | unix.PARENB
+		t.Cflag |= unix.CS8
+		t.Cc[unix.VMIN] = 1
+		t.Cc[unix.VTIME] = 0
+		return unix.IoctlSetTermios(fd, unix.TCSETS, t)
+	})
+}
+
+// newPty opens a new pty pair, with its slave side in raw mode. The master
+// is left blocking by console.NewPty, reads on it end once the slave is
+// closed.
+func newPty() (console.Console, *os.File, error) {
+	master, path, err := console.NewPty()
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening pty: %w", err)
+	}
+	slave, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("opening pty slave: %w", err)
+	}
+	if err := setRaw(slave); err != nil {
+		slave.Close()
+		master.Close()
+		return nil, nil, fmt.Errorf("setting pty slave raw: %w", err)
+	}
+	return master, slave, nil
+}
@@ -0,0 +1,237 @@
+package io
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "mica-shim/logger"
+
+	"github.com/containerd/containerd/sys/reaper"
+	runc "github.com/containerd/go-runc"
+)
+
+// Schemes of the stdio URIs containerd passes for the stdout and stderr of a
+// task. A plain path is a fifo.
+const (
+	schemeFifo   = "fifo"
+	schemeBinary = "binary"
+	schemeFile   = "file"
+)
+
+// binaryLoggerTermTimeout is how long a logging binary gets to flush and exit
+// after SIGTERM before it is killed.
+const binaryLoggerTermTimeout = 12 * time.Second
+
+// Output tells where the stdout and stderr of a task go.
+type Output struct {
+	// ID and Namespace identify the task to logging binaries.
+	ID        string
+	Namespace string
+	// Stdout and Stderr are fifo paths, or binary:// or file:// URIs. With a
+	// URI both streams go to the stdout URI, as containerd sets both alike.
+	Stdout string
+	Stderr string
+}
+
+// Open opens the stdout and stderr of a task. Closing the returned closer
+// closes both, further closes do nothing.
+func (o Output) Open(ctx context.Context) (stdout, stderr io.Writer, _ io.Closer, _ error) {
+	var closers []io.Closer
+	stdout, stderr, err := o.open(ctx, &closers)
+	if err != nil {
+		closeAll(closers)
+		return nil, nil, nil, err
+	}
+	return stdout, stderr, &multiCloser{closers: closers}, nil
+}
+
+// multiCloser closes all its closers once, in reverse order.
+type multiCloser struct {
+	once    sync.Once
+	closers []io.Closer
+	err     error
+}
+
+func (m *multiCloser) Close() error {
+	m.once.Do(func() { m.err = closeAll(m.closers) })
+	return m.err
+}
+
+// open opens the stdout and stderr of a task, adding what needs to be closed
+// to closers. Output to an empty path is discarded.
+func (o Output) open(ctx context.Context, closers *[]io.Closer) (stdout, stderr io.Writer, _ error) {
+	if o.Stdout == "" {
+		return io.Discard, io.Discard, nil
+	}
+
+	// fifo paths are used as they are, they need not be valid URIs
+	scheme, path, ok := strings.Cut(o.Stdout, "://")
+	if !ok || scheme == schemeFifo {
+		if !ok {
+			path = o.Stdout
+		}
+		var err error
+		if stdout, err = openFifoOutput(ctx, path, closers); err != nil {
+			return nil, nil, err
+		}
+		if stderr, err = openFifoOutput(ctx, strings.TrimPrefix(o.Stderr, schemeFifo+"://"), closers); err != nil {
+			return nil, nil, err
+		}
+		return stdout, stderr, nil
+	}
+
+	u, err := url.Parse(o.Stdout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing stdout uri %s: %w", o.Stdout, err)
+	}
+
+	switch u.Scheme {
+	case schemeFile:
+		f, err := openLogFile(u.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		*closers = append(*closers, f)
+		return f, f, nil
+	case schemeBinary:
+		l, err := startBinaryLogger(u, o.ID, o.Namespace)
+		if err != nil {
+			return nil, nil, err
+		}
+		*closers = append(*closers, l)
+		return l.stdout, l.stderr, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown stdio scheme %s", u.Scheme)
+	}
+}
+
+// openFifoOutput opens the named pipe at path for writing, adding what needs
+// to be closed to closers. Output is discarded if path is empty.
+func openFifoOutput(ctx context.Context, path string, closers *[]io.Closer) (io.Writer, error) {
+	if path == "" {
+		return io.Discard, nil
+	}
+	fw, fr, err := openFifoWriter(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	*closers = append(*closers, fw, fr)
+	return fw, nil
+}
+
+// openLogFile opens the file at path for appending, creating it and its
+// directory if needed.
+func openLogFile(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("creating log directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening log file %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// binaryLogger is a logging binary run for a binary:// URI. As with the
+// containerd shims, it reads the stdout and stderr of the task from fds 3 and
+// 4, and closes fd 5 once it is ready.
+type binaryLogger struct {
+	cmd            *exec.Cmd
+	ec             chan runc.Exit
+	stdout, stderr *os.File
+}
+
+// startBinaryLogger starts the logging binary at the path of u, with the
+// query parameters of u as arguments, and waits for it to be ready.
+func startBinaryLogger(u *url.URL, id, ns string) (_ *binaryLogger, retErr error) {
+	var closers []io.Closer
+	defer func() {
+		if retErr != nil {
+			closeAll(closers)
+		}
+	}()
+
+	out, err := newPipe()
+	if err != nil {
+		return nil, fmt.Errorf("creating stdout pipe: %w", err)
+	}
+	closers = append(closers, out)
+	serr, err := newPipe()
+	if err != nil {
+		return nil, fmt.Errorf("creating stderr pipe: %w", err)
+	}
+	closers = append(closers, serr)
+	ready, err := newPipe()
+	if err != nil {
+		return nil, fmt.Errorf("creating ready pipe: %w", err)
+	}
+	closers = append(closers, ready)
+
+	var args []string
+	for k, vs := range u.Query() {
+		args = append(args, k)
+		if len(vs) > 0 {
+			args = append(args, vs[0])
+		}
+	}
+	cmd := exec.Command(u.Path, args...)
+	cmd.Env = []string{"CONTAINER_ID=" + id, "CONTAINER_NAMESPACE=" + ns}
+	cmd.ExtraFiles = []*os.File{out.r, serr.r, ready.w}
+	// the shim reaps all its children, so wait through its reaper
+	ec, err := reaper.Default.Start(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("starting logging binary %s: %w", u.Path, err)
+	}
+	l := &binaryLogger{cmd: cmd, ec: ec, stdout: out.w, stderr: serr.w}
+	closers = append(closers, l)
+
+	// only the logging binary keeps the read ends and the ready pipe open
+	if err := errors.Join(out.r.Close(), serr.r.Close(), ready.w.Close()); err != nil {
+		return nil, fmt.Errorf("closing logging binary pipes: %w", err)
+	}
+	b := make([]byte, 1)
+	if _, err := ready.r.Read(b); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("waiting for logging binary %s: %w", u.Path, err)
+	}
+	ready.r.Close()
+
+	return l, nil
+}
+
+// Close closes the output pipes of l and stops the logging binary, giving it
+// a chance to flush first.
+func (l *binaryLogger) Close() error {
+	err := errors.Join(l.stdout.Close(), l.stderr.Close())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := reaper.Default.Wait(l.cmd, l.ec); err != nil {
+			log.WithError(err).Warnf("failed to wait for logging binary %s", l.cmd.Path)
+		}
+	}()
+
+	if serr := l.cmd.Process.Signal(syscall.SIGTERM); serr != nil && !errors.Is(serr, os.ErrProcessDone) {
+		log.WithError(serr).Warnf("failed to terminate logging binary %s, killing it", l.cmd.Path)
+		l.cmd.Process.Kill()
+	}
+
+	select {
+	case <-done:
+	case <-time.After(binaryLoggerTermTimeout):
+		log.Warnf("logging binary %s did not exit, killing it", l.cmd.Path)
+		l.cmd.Process.Kill()
+		<-done
+	}
+	return err
+}
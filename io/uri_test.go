@@ -0,0 +1,140 @@
+package io
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/sys/reaper"
+	"golang.org/x/sys/unix"
+)
+
+func TestOutputFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "logs", "task.log")
+
+	var closers []io.Closer
+	stdout, stderr, err := Output{Stdout: "file://" + path, Stderr: "file://" + path}.open(ctx, &closers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(stdout, "uart:~$ \n")
+	io.WriteString(stderr, "<err> main: oops\n")
+	if err := closeAll(closers); err != nil {
+		t.Fatal(err)
+	}
+
+	// appends to what is there already
+	closers = nil
+	stdout, _, err = Output{Stdout: "file://" + path}.open(ctx, &closers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(stdout, "done\n")
+	closeAll(closers)
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "uart:~$ \n<err> main: oops\ndone\n"; string(b) != want {
+		t.Errorf("expected log file %q, got %q", want, b)
+	}
+}
+
+// reapChildren reaps the exited children of the test process, as the shim
+// does on SIGCHLD, for the processes waited for through reaper.Default.
+func reapChildren(t *testing.T) {
+	sigs := make(chan os.Signal, 32)
+	signal.Notify(sigs, unix.SIGCHLD)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-sigs:
+				reaper.Reap()
+			case <-done:
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		signal.Stop(sigs)
+		close(done)
+	})
+}
+
+func TestOutputFifo(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// fifo paths are no URIs, whatever they contain
+	path := filepath.Join(t.TempDir(), "stdout-100%?x")
+	out := openClientFifo(ctx, t, path, unix.O_RDONLY)
+
+	var closers []io.Closer
+	stdout, stderr, err := Output{Stdout: path}.open(ctx, &closers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeAll(closers)
+	if stderr != io.Discard {
+		t.Error("expected output to an empty stderr to be discarded")
+	}
+
+	io.WriteString(stdout, "uart:~$ \n")
+	b := make([]byte, 9)
+	if _, err := io.ReadFull(out, b); err != nil || string(b) != "uart:~$ \n" {
+		t.Errorf("expected output in the fifo, got %q: %v", b, err)
+	}
+}
+
+func TestOutputBinary(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	reapChildren(t)
+
+	dir := t.TempDir()
+	logger := filepath.Join(dir, "logger")
+	script := `#!/bin/sh
+trap '' TERM
+echo "$CONTAINER_NAMESPACE/$CONTAINER_ID $*" > ` + dir + `/env
+cat <&3 > ` + dir + `/stdout 4<&- 5>&- &
+cat <&4 > ` + dir + `/stderr 3<&- 5>&- &
+exec 5>&-
+wait
+`
+	if err := os.WriteFile(logger, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	var closers []io.Closer
+	out := Output{ID: "zephyr", Namespace: "default", Stdout: "binary://" + logger + "?--tag=rtos"}
+	stdout, stderr, err := out.open(ctx, &closers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(stdout, "uart:~$ \n")
+	io.WriteString(stderr, "<err> main: oops\n")
+	if err := closeAll(closers); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"env":    "default/zephyr --tag rtos\n",
+		"stdout": "uart:~$ \n",
+		"stderr": "<err> main: oops\n",
+	} {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Errorf("expected logger %s %q, got %q", name, want, b)
+		}
+	}
+}
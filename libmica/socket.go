@@ -11,6 +11,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -22,15 +23,26 @@ const (
 	MStop   MicaCommand = "stop"
 	MRemove MicaCommand = "remove"
 	MStatus MicaCommand = "status"
+	// MPause and MResume halt and continue the core of a client without
+	// releasing it. Older micad versions do not know them.
+	MPause  MicaCommand = "pause"
+	MResume MicaCommand = "resume"
+)
+
+// Sizes of the string fields of the create message, including the trailing NUL
+// micad expects.
+const (
+	MaxNameLen = 32
+	MaxPathLen = 128
 )
 
 // NOTICE: we have to ensure the length of each field consistency with the length of the field in mica daemon
 // TODO: add explaination for each field
 type micaCreateMsg struct {
 	cpu  uint32
-	name [32]byte
-	// relative path in bundle
-	path   [128]byte
+	name [MaxNameLen]byte
+	// host path of the firmware, readable by micad
+	path   [MaxPathLen]byte
 	ped    [32]byte
 	pedcfg [128]byte
 	debug  bool
@@ -111,10 +123,12 @@ func (ms *micaSocket) tx(data []byte) error {
 	return err
 }
 
-func (ms *micaSocket) rx() (string, error) {
+// rx reads the response of micad, returning the MICA-SUCCESS/MICA-FAILED
+// result and the output micad printed before it.
+func (ms *micaSocket) rx() (string, string, error) {
 	log.LocateDebugf("Receiving message from MicaSocket")
 	if ms.conn == nil {
-		return "", errors.New("socket not connected")
+		return "", "", errors.New("socket not connected")
 	}
 
 	ms.conn.SetReadDeadline(time.Now().Add(defs.MicaSocketTimout))
@@ -127,9 +141,9 @@ func (ms *micaSocket) rx() (string, error) {
 		log.Debugf("Received %d bytes chunk from %s", n, ms.conn.RemoteAddr())
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				return "", errors.New("timeout while waiting for micad response")
+				return "", "", errors.New("timeout while waiting for micad response")
 			}
-			return "", err
+			return "", "", err
 		}
 
 		if n == 0 {
@@ -145,51 +159,57 @@ func (ms *micaSocket) rx() (string, error) {
 			if msg != "" {
 				log.Error(msg)
 			}
-			return defs.MicaFailed, nil
+			return defs.MicaFailed, msg, nil
 		} else if strings.Contains(responseBuffer, defs.MicaSuccess) {
 			parts := strings.Split(responseBuffer, defs.MicaSuccess)
 			msg := strings.TrimSpace(parts[0])
 			if msg != "" {
 				log.Info(msg)
 			}
-			return defs.MicaSuccess, nil
+			return defs.MicaSuccess, msg, nil
 		}
 	}
 
-	return "", errors.New("unexpected response format")
+	return "", "", errors.New("unexpected response format")
 }
 
 // TODO: We need to manually fetch information from managed clients
 // Because mica daemon print clients information by its own format, which is not
 // compatible with containerd
 func (ms *micaSocket) handleMsg(msg []byte) (string, error) {
+	response, _, err := ms.handleMsgOutput(msg)
+	return response, err
+}
+
+// handleMsgOutput is handleMsg also returning the output micad printed.
+func (ms *micaSocket) handleMsgOutput(msg []byte) (string, string, error) {
 	log.LocateDebugf("Handling message with socket: %s", ms.socketPath)
 
 	if err := ms.connect(); err != nil {
-		return "", fmt.Errorf("failed to connect to socket: %v", err)
+		return "", "", fmt.Errorf("failed to connect to socket: %w", err)
 	}
 	defer ms.close()
 
 	if err := ms.tx(msg); err != nil {
-		return "", fmt.Errorf("failed to send command: %v", err)
+		return "", "", fmt.Errorf("failed to send command: %v", err)
 	}
 
-	response, err := ms.rx()
+	response, output, err := ms.rx()
 	log.LocateDebugf("Received response: %s, error: %v", response, err)
 	if err != nil {
-		return "", fmt.Errorf("failed to receive response: %v", err)
+		return "", "", fmt.Errorf("failed to receive response: %v", err)
 	}
 
 	switch response {
 	case defs.MicaSuccess:
 		log.LocateDebugf("Command executed successfully: %s", response)
-		return response, nil
+		return response, output, nil
 	case defs.MicaFailed:
 		log.LocateDebugf("Command failed: %s", response)
-		return response, fmt.Errorf("mica daemon reported failure")
+		return response, output, fmt.Errorf("mica daemon reported failure")
 	default:
 		log.LocateDebugf("Received unexpected response: %s", response)
-		return response, fmt.Errorf("unexpected response format: %s", response)
+		return response, output, fmt.Errorf("unexpected response format: %s", response)
 	}
 }
 
@@ -197,21 +217,93 @@ func (ms *micaSocket) handleMsg(msg []byte) (string, error) {
 
 // MicaCreate creates a new mica client; while MicaCtl is used to control the mica client
 func MicaCreate(config micaCreateMsg) (string, error) {
-	s := newMicaSocket(defs.MicaCreatSocketPath)
+	s := newMicaSocket(createSocketPath())
 
 	return s.handleMsg(config.pack())
 }
 
 func MicaCtl(cmd MicaCommand, client string) (string, error) {
-	if !validSocketPath(defs.MicaCreatSocketPath) {
+	response, _, err := micaCtlOutput(cmd, client)
+	return response, err
+}
+
+// micaCtlOutput sends cmd to the control socket of client and also returns
+// the output micad printed.
+func micaCtlOutput(cmd MicaCommand, client string) (string, string, error) {
+	if !validSocketPath(createSocketPath()) {
 		log.Debug("mica socket directory does not exist, please check if micad is running")
-		return "", fmt.Errorf("mica socket directory does not exist, please check if micad is running")
+		return "", "", fmt.Errorf("mica socket directory does not exist, please check if micad is running")
 	}
-	target := filepath.Join(defs.MicaSocketDir, client+".socket")
+	target := clientSocketPath(client)
 	log.LocateDebugf("client socket path: %s", target)
 	s := newMicaSocket(target)
 	msg := string(cmd)
-	return s.handleMsg([]byte(msg))
+	return s.handleMsgOutput([]byte(msg))
+}
+
+// SocketDir is the directory micad creates its sockets in. Only tests running
+// a fake micad change it.
+var SocketDir = defs.MicaSocketDir
+
+// createSocketPath returns the path of the socket micad takes create messages
+// on.
+func createSocketPath() string {
+	return filepath.Join(SocketDir, defs.MicaSocketName)
+}
+
+// clientSocketPath returns the path of the control socket of client.
+func clientSocketPath(client string) string {
+	return filepath.Join(SocketDir, client+".socket")
+}
+
+// MicadRunning tells whether micad listens for create messages.
+func MicadRunning() bool {
+	return validSocketPath(createSocketPath())
+}
+
+// ListClients returns the names of the clients micad has a control socket
+// for.
+func ListClients() ([]string, error) {
+	entries, err := os.ReadDir(SocketDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var clients []string
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".socket")
+		if !ok || e.Name() == defs.MicaSocketName || e.Type()&os.ModeSocket == 0 {
+			continue
+		}
+		clients = append(clients, name)
+	}
+	return clients, nil
+}
+
+// MicadInstance identifies the running micad by the inode of its create
+// socket, which micad creates anew whenever it starts.
+func MicadInstance() (uint64, error) {
+	fi, err := os.Stat(createSocketPath())
+	if err != nil {
+		return 0, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || fi.Mode()&os.ModeSocket == 0 {
+		return 0, fmt.Errorf("%s is not a socket", createSocketPath())
+	}
+	return uint64(st.Ino), nil
+}
+
+// Unreachable tells whether err is a failure to reach micad, as when it died
+// leaving its sockets behind.
+func Unreachable(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ENOENT)
+}
+
+// ClientExists tells whether micad has a control socket for client, that is
+// whether the client was created and not removed since.
+func ClientExists(client string) bool {
+	return validSocketPath(clientSocketPath(client))
 }
 
 // NewMicaCreateMsg creates a properly initialized micaCreateMsg
@@ -229,7 +321,7 @@ func dummyCreateMsg() micaCreateMsg {
 
 // Public test functions:
 func TestCreate() (string, error) {
-	s := newMicaSocket(defs.MicaCreatSocketPath)
+	s := newMicaSocket(createSocketPath())
 	defer s.close()
 	s.connect()
 	msg := dummyCreateMsg()
@@ -0,0 +1,65 @@
+package libmica
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ttyPattern matches the RPMsg TTY devices micad lists among client services.
+var ttyPattern = regexp.MustCompile(`/dev/tty[A-Za-z]*RPMSG\d+`)
+
+// ClientStatus is the status of a mica client, as printed by micad:
+//
+//	Name                          Assigned CPU        State               Service
+//	qemu-zephyr                   3                   Running             rpmsg-tty(/dev/ttyRPMSG0)
+type ClientStatus struct {
+	Name     string
+	CPU      int
+	State    string
+	Services []string
+}
+
+// TTY returns the RPMsg TTY device of the client, empty if it has none.
+func (st *ClientStatus) TTY() string {
+	for _, svc := range st.Services {
+		if dev := ttyPattern.FindString(svc); dev != "" {
+			return dev
+		}
+	}
+	return ""
+}
+
+// MicaStatus queries micad for the status of client.
+func MicaStatus(client string) (*ClientStatus, error) {
+	_, output, err := micaCtlOutput(MStatus, client)
+	if err != nil {
+		return nil, err
+	}
+	return parseStatus(output, client)
+}
+
+// parseStatus finds the status line of client in the output of micad.
+func parseStatus(output, client string) (*ClientStatus, error) {
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] != client {
+			continue
+		}
+
+		st := &ClientStatus{Name: fields[0], CPU: -1, State: fields[2]}
+		if cpu, err := strconv.Atoi(fields[1]); err == nil {
+			st.CPU = cpu
+		}
+		if len(fields) > 3 {
+			for _, svc := range strings.Split(strings.Join(fields[3:], " "), ",") {
+				if svc = strings.TrimSpace(svc); svc != "" {
+					st.Services = append(st.Services, svc)
+				}
+			}
+		}
+		return st, nil
+	}
+	return nil, fmt.Errorf("no status for client %s in micad output %q", client, output)
+}
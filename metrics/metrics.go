@@ -0,0 +1,82 @@
+// Package metrics defines the metrics the shim reports for mica tasks.
+package metrics
+
+import (
+	"time"
+
+	"mica-shim/events"
+
+	"github.com/containerd/typeurl/v2"
+)
+
+// TypeURL is the type URL of Metrics in the Any of a Stats response.
+const TypeURL = "org.openeuler.mica/metrics/v1/Metrics"
+
+func init() {
+	typeurl.Register(&Metrics{}, TypeURL)
+}
+
+// Metrics are the metrics of a mica task, returned JSON encoded by the Stats
+// RPC of the shim. Decode them with typeurl.UnmarshalAny once this package is
+// imported.
+type Metrics struct {
+	// Client is the name of the mica client of the task and State its state
+	// as reported by micad, empty if micad did not answer.
+	Client string `json:"client"`
+	State  string `json:"state"`
+	// Paused tells whether the task is paused.
+	Paused bool `json:"paused"`
+	// CPU is the core the RTOS runs on.
+	CPU uint32 `json:"cpu"`
+	// Firmware describes the firmware the client runs.
+	Firmware Firmware `json:"firmware"`
+	// StartedAt is when the firmware last booted, and UptimeSeconds how
+	// long it has been running since, zero unless running.
+	StartedAt     time.Time `json:"started_at,omitempty"`
+	UptimeSeconds uint64    `json:"uptime_seconds"`
+	// MicadErrors counts the requests to micad about the task that failed.
+	MicadErrors uint64 `json:"micad_errors"`
+	// Restarts counts the restarts of the client by the restart policy of
+	// the task, and LastFailure tells why the client last stopped on its
+	// own.
+	Restarts    int    `json:"restarts"`
+	LastFailure string `json:"last_failure,omitempty"`
+	// Console counts the output of the RTOS.
+	Console Console `json:"console"`
+	// Host has the counters Linux keeps for the RTOS core, nil when the core
+	// is not visible to Linux, as is usual once it has been taken offline.
+	Host *HostCPU `json:"host,omitempty"`
+}
+
+// Firmware describes the firmware image of a task: its digest in the
+// firmware store, and what its ELF headers tell.
+type Firmware struct {
+	Digest   string `json:"digest"`
+	BuildID  string `json:"build_id,omitempty"`
+	Entry    uint64 `json:"entry"`
+	Segments int    `json:"segments"`
+	// LastUpdate is the outcome of the last replacement of the firmware
+	// through the Update RPC, nil if there was none.
+	LastUpdate *events.FirmwareUpdate `json:"last_update,omitempty"`
+}
+
+// Console counts the bytes read from the console and the log devices of a
+// task.
+type Console struct {
+	StdoutBytes uint64 `json:"stdout_bytes"`
+	StderrBytes uint64 `json:"stderr_bytes"`
+}
+
+// HostCPU are the counters of a core in /proc/stat, in clock ticks, and its
+// total count of interrupts in /proc/interrupts.
+type HostCPU struct {
+	User       uint64 `json:"user"`
+	Nice       uint64 `json:"nice"`
+	System     uint64 `json:"system"`
+	Idle       uint64 `json:"idle"`
+	IOWait     uint64 `json:"iowait"`
+	IRQ        uint64 `json:"irq"`
+	SoftIRQ    uint64 `json:"softirq"`
+	Steal      uint64 `json:"steal"`
+	Interrupts uint64 `json:"interrupts"`
+}
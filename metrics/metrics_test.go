@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/containerd/typeurl/v2"
+)
+
+func TestMetricsAny(t *testing.T) {
+	any, err := typeurl.MarshalAny(&Metrics{
+		Client:   "zephyr",
+		State:    "Running",
+		CPU:      3,
+		Firmware: Firmware{Digest: "sha256:0123", BuildID: "abcd", Entry: 0x40000000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if any.GetTypeUrl() != TypeURL {
+		t.Errorf("unexpected type url %s", any.GetTypeUrl())
+	}
+
+	v, err := typeurl.UnmarshalAny(any)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := v.(*Metrics)
+	if !ok || m.Client != "zephyr" || m.State != "Running" || m.CPU != 3 ||
+		m.Firmware.BuildID != "abcd" || m.Firmware.Entry != 0x40000000 {
+		t.Errorf("unexpected metrics %#v", v)
+	}
+}
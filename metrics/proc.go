@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Paths of the host counters.
+var (
+	procStat       = "/proc/stat"
+	procInterrupts = "/proc/interrupts"
+)
+
+// errNoCPU is returned when Linux has no counters for a core.
+var errNoCPU = errors.New("no counters for cpu")
+
+// ReadHostCPU reads the counters Linux keeps for cpu. It returns nil if the
+// core is not visible to Linux.
+func ReadHostCPU(cpu uint32) (*HostCPU, error) {
+	f, err := os.Open(procStat)
+	if err != nil {
+		return nil, fmt.Errorf("reading cpu counters: %w", err)
+	}
+	defer f.Close()
+	h, err := parseStat(f, cpu)
+	if errors.Is(err, errNoCPU) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	irqs, err := os.Open(procInterrupts)
+	if err != nil {
+		return nil, fmt.Errorf("reading interrupt counters: %w", err)
+	}
+	defer irqs.Close()
+	if h.Interrupts, err = parseInterrupts(irqs, cpu); err != nil && !errors.Is(err, errNoCPU) {
+		return nil, err
+	}
+	return h, nil
+}
+
+// parseStat returns the counters of the cpu<cpu> line of /proc/stat.
+func parseStat(r io.Reader, cpu uint32) (*HostCPU, error) {
+	name := fmt.Sprintf("cpu%d", cpu)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || fields[0] != name {
+			continue
+		}
+
+		var v [8]uint64
+		for i := range v {
+			if i+1 >= len(fields) {
+				break
+			}
+			n, err := strconv.ParseUint(fields[i+1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s counters: %w", name, err)
+			}
+			v[i] = n
+		}
+		return &HostCPU{
+			User:    v[0],
+			Nice:    v[1],
+			System:  v[2],
+			Idle:    v[3],
+			IOWait:  v[4],
+			IRQ:     v[5],
+			SoftIRQ: v[6],
+			Steal:   v[7],
+		}, nil
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("reading cpu counters: %w", err)
+	}
+	return nil, fmt.Errorf("%s: %w", name, errNoCPU)
+}
+
+// parseInterrupts returns the total count of interrupts of cpu in
+// /proc/interrupts, which has a column per online core.
+func parseInterrupts(r io.Reader, cpu uint32) (uint64, error) {
+	sc := bufio.NewScanner(r)
+	if !sc.Scan() {
+		return 0, fmt.Errorf("reading interrupt counters: %w", io.ErrUnexpectedEOF)
+	}
+	col := -1
+	for i, name := range strings.Fields(sc.Text()) {
+		if name == fmt.Sprintf("CPU%d", cpu) {
+			col = i
+		}
+	}
+	if col < 0 {
+		return 0, fmt.Errorf("CPU%d: %w", cpu, errNoCPU)
+	}
+
+	var total uint64
+	for sc.Scan() {
+		// lines such as "ERR: 0" have a single column
+		fields := strings.Fields(sc.Text())
+		if len(fields) < col+2 {
+			continue
+		}
+		n, err := strconv.ParseUint(fields[col+1], 10, 64)
+		if err != nil {
+			continue
+		}
+		total += n
+	}
+	if err := sc.Err(); err != nil {
+		return 0, fmt.Errorf("reading interrupt counters: %w", err)
+	}
+	return total, nil
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testStat = `cpu  100 0 50 1000 5 1 2 0 0 0
+cpu0 60 0 30 500 3 1 1 0 0 0
+cpu2 40 1 20 500 2 0 1 7 0 0
+intr 12345
+`
+
+const testInterrupts = `           CPU0       CPU2
+  1:         10          5   IO-APIC   1-edge      i8042
+ 24:          3        200   PCI-MSI 65536-edge      virtio0
+IPI0:        100         40   Rescheduling interrupts
+ERR:          0
+`
+
+func TestParseStat(t *testing.T) {
+	h, err := parseStat(strings.NewReader(testStat), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := HostCPU{User: 40, Nice: 1, System: 20, Idle: 500, IOWait: 2, SoftIRQ: 1, Steal: 7}
+	if *h != want {
+		t.Errorf("unexpected counters %+v", h)
+	}
+
+	if _, err := parseStat(strings.NewReader(testStat), 3); !errors.Is(err, errNoCPU) {
+		t.Errorf("expected no counters for an offline cpu, got %v", err)
+	}
+}
+
+func TestParseInterrupts(t *testing.T) {
+	n, err := parseInterrupts(strings.NewReader(testInterrupts), 2)
+	if err != nil || n != 245 {
+		t.Errorf("unexpected interrupt count %d: %v", n, err)
+	}
+
+	if _, err := parseInterrupts(strings.NewReader(testInterrupts), 1); !errors.Is(err, errNoCPU) {
+		t.Errorf("expected no counters for an offline cpu, got %v", err)
+	}
+}